@@ -0,0 +1,288 @@
+// Copyright 2013 The Go-SQLite Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+/*
+Command sqlite3codec converts SQLite databases between plaintext and the formats
+implemented by the github.com/mxk/go-sqlite/sqlite3/codec package, and checks
+the integrity of encoded database files.
+
+Usage:
+
+	sqlite3codec info <db>
+	sqlite3codec verify [-key=<key>] <db>
+	sqlite3codec encrypt -key=<key> <src> <dst>
+	sqlite3codec decrypt -key=<key> <src> <dst>
+	sqlite3codec rekey -key=<key> -newkey=<key> <src> <dst>
+
+Keys use the same format as Conn.Key (e.g. "aes-hmac::secret"). They may also be
+provided via SQLITE3CODEC_KEY and SQLITE3CODEC_NEWKEY environment variables to
+keep them out of the process list.
+
+The info command prints the page size and reserve values from the database
+header. The verify command decodes every page of the database file and reports
+the pages that fail authentication. It does not check the journal or WAL files,
+so it should only be used on databases that are not in use. Without a key, the
+database must be plaintext, and verify reports the output of PRAGMA
+integrity_check instead.
+
+The encrypt, decrypt, and rekey commands create a new destination database,
+which must not exist, with Conn.BackupTo. The online backup API is used when the
+source and destination have the same reserve values. Otherwise, the schema and
+contents are copied using SQL statements, which has the same effect as VACUUM
+(ROWIDs of tables without an INTEGER PRIMARY KEY may change). The page size,
+auto-vacuum mode, user_version, and application_id values are preserved.
+*/
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/mxk/go-sqlite/sqlite3"
+	_ "github.com/mxk/go-sqlite/sqlite3/codec"
+)
+
+// magic is the header string found at the start of every plaintext database.
+const magic = "SQLite format 3\x00"
+
+func main() {
+	if len(os.Args) < 2 {
+		usage()
+	}
+	cmd := os.Args[1]
+	fs := flag.NewFlagSet(cmd, flag.ExitOnError)
+	key := fs.String("key", os.Getenv("SQLITE3CODEC_KEY"), "codec key")
+	newKey := fs.String("newkey", os.Getenv("SQLITE3CODEC_NEWKEY"), "new codec key")
+	fs.Parse(os.Args[2:])
+	args := fs.Args()
+
+	var err error
+	switch {
+	case cmd == "info" && len(args) == 1:
+		err = info(os.Stdout, args[0])
+	case cmd == "verify" && len(args) == 1:
+		err = verify(os.Stdout, args[0], []byte(*key))
+	case cmd == "encrypt" && len(args) == 2 && *key != "":
+		err = convert(args[0], nil, args[1], []byte(*key))
+	case cmd == "decrypt" && len(args) == 2 && *key != "":
+		err = convert(args[0], []byte(*key), args[1], nil)
+	case cmd == "rekey" && len(args) == 2 && *key != "" && *newKey != "":
+		err = convert(args[0], []byte(*key), args[1], []byte(*newKey))
+	default:
+		usage()
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "sqlite3codec:", err)
+		os.Exit(1)
+	}
+}
+
+func usage() {
+	fmt.Fprintln(os.Stderr, "usage: sqlite3codec info <db>")
+	fmt.Fprintln(os.Stderr, "       sqlite3codec verify [-key=<key>] <db>")
+	fmt.Fprintln(os.Stderr, "       sqlite3codec encrypt -key=<key> <src> <dst>")
+	fmt.Fprintln(os.Stderr, "       sqlite3codec decrypt -key=<key> <src> <dst>")
+	fmt.Fprintln(os.Stderr, "       sqlite3codec rekey -key=<key> -newkey=<key> <src> <dst>")
+	os.Exit(2)
+}
+
+// header contains the database header fields that are never encoded.
+type header struct {
+	PageSize int
+	Reserve  int
+	Encoded  bool
+}
+
+// readHeader reads the header of database file f.
+func readHeader(f io.ReaderAt) (*header, error) {
+	var b [24]byte
+	if _, err := f.ReadAt(b[:], 0); err != nil {
+		if err == io.EOF {
+			err = errors.New("file is empty or too short")
+		}
+		return nil, err
+	}
+	return parseHeader(b[:])
+}
+
+// parseHeader decodes bytes 0 through 23 of a database file.
+func parseHeader(b []byte) (*header, error) {
+	h := &header{
+		PageSize: int(b[16])<<8 | int(b[17]),
+		Reserve:  int(b[20]),
+		Encoded:  !bytes.Equal(b[:16], []byte(magic)),
+	}
+	if h.PageSize == 1 {
+		h.PageSize = 65536
+	}
+	if h.PageSize < 512 || h.PageSize&(h.PageSize-1) != 0 ||
+		h.PageSize-h.Reserve < 480 {
+		return nil, errors.New("file is not a database")
+	}
+	return h, nil
+}
+
+// info prints the header of database file path.
+func info(w io.Writer, path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	h, err := readHeader(f)
+	if err != nil {
+		return err
+	}
+	fi, err := f.Stat()
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(w, "page size: %d\n", h.PageSize)
+	fmt.Fprintf(w, "reserve:   %d\n", h.Reserve)
+	fmt.Fprintf(w, "pages:     %d\n", fi.Size()/int64(h.PageSize))
+	fmt.Fprintf(w, "encoded:   %t\n", h.Encoded)
+	return nil
+}
+
+// verify decodes every page of database file path and reports the pages that
+// could not be decoded. Plaintext databases are checked with integrityCheck if
+// key is empty.
+func verify(w io.Writer, path string, key []byte) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	h, err := readHeader(f)
+	if err != nil {
+		return err
+	}
+	if len(key) == 0 {
+		if h.Encoded {
+			return errors.New("database is encoded, a key is required")
+		}
+		return integrityCheck(w, path)
+	}
+	c, err := sqlite3.NewCodec(&sqlite3.CodecCtx{
+		Path:     path,
+		Name:     "main",
+		PageSize: h.PageSize,
+		Reserve:  h.Reserve,
+		Fixed:    true,
+	}, key)
+	if err != nil {
+		return err
+	}
+	if c == nil {
+		return errors.New("codec is disabled by the key")
+	}
+	defer c.Free()
+	if n := c.Reserve(); n >= 0 && n != h.Reserve {
+		return fmt.Errorf("codec requires %d reserved bytes, database has %d",
+			n, h.Reserve)
+	}
+	c.Resize(h.PageSize, h.Reserve)
+
+	page := make([]byte, h.PageSize)
+	pgno, failed := uint32(0), 0
+	for {
+		pgno++
+		if _, err = io.ReadFull(f, page); err != nil {
+			if err == io.EOF {
+				break
+			}
+			return err
+		}
+		if err := c.Decode(page, pgno, 3); err != nil {
+			fmt.Fprintf(w, "page %d: %v\n", pgno, err)
+			failed++
+		} else if pgno == 1 && !bytes.Equal(page[:16], []byte(magic)) {
+			fmt.Fprintf(w, "page %d: invalid database header\n", pgno)
+			failed++
+		}
+	}
+	fmt.Fprintf(w, "%d page(s) verified, %d failed\n", pgno-1, failed)
+	if failed > 0 {
+		return fmt.Errorf("%s failed verification", path)
+	}
+	return nil
+}
+
+// integrityCheck runs PRAGMA integrity_check on database file path and reports
+// any problems that were found.
+func integrityCheck(w io.Writer, path string) error {
+	c, err := open(path, nil)
+	if err != nil {
+		return err
+	}
+	defer c.Close()
+	s, err := c.Query("PRAGMA integrity_check")
+	if err != nil {
+		return err
+	}
+	defer s.Close()
+	ok := true
+	var msg string
+	for ; err == nil; err = s.Next() {
+		if err = s.Scan(&msg); err != nil {
+			return err
+		}
+		fmt.Fprintln(w, msg)
+		ok = ok && msg == "ok"
+	}
+	if err != io.EOF {
+		return err
+	}
+	if !ok {
+		return fmt.Errorf("%s failed integrity check", path)
+	}
+	return nil
+}
+
+// convert copies database src to a new database dst, changing the codec key.
+func convert(src string, srcKey []byte, dst string, dstKey []byte) error {
+	if _, err := os.Stat(dst); !os.IsNotExist(err) {
+		return fmt.Errorf("%s already exists", dst)
+	}
+	err := backup(src, srcKey, dst, dstKey)
+	if err != nil {
+		os.Remove(dst)
+	}
+	return err
+}
+
+// open opens a database connection and sets the codec key, if any.
+func open(path string, key []byte) (*sqlite3.Conn, error) {
+	c, err := sqlite3.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	if len(key) > 0 {
+		if err = c.Key("main", key); err != nil {
+			c.Close()
+			return nil, err
+		}
+	}
+	return c, nil
+}
+
+// backup copies src to dst using Conn.BackupTo.
+func backup(src string, srcKey []byte, dst string, dstKey []byte) error {
+	sc, err := open(src, srcKey)
+	if err != nil {
+		return err
+	}
+	defer sc.Close()
+	dc, err := open(dst, dstKey)
+	if err != nil {
+		return err
+	}
+	defer dc.Close()
+	return sc.BackupTo(context.Background(), dc, nil)
+}
@@ -0,0 +1,147 @@
+// Copyright 2013 The Go-SQLite Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/mxk/go-sqlite/sqlite3"
+)
+
+func TestParseHeader(t *testing.T) {
+	hdr := func(prefix string, pageSize uint16, reserve byte) []byte {
+		b := make([]byte, 24)
+		copy(b, prefix)
+		b[16], b[17], b[20] = byte(pageSize>>8), byte(pageSize), reserve
+		return b
+	}
+	tests := []struct {
+		in  []byte
+		out *header
+	}{
+		{hdr(magic, 1024, 0), &header{1024, 0, false}},
+		{hdr(magic, 4096, 32), &header{4096, 32, false}},
+		{hdr("encrypted data..", 1, 28), &header{65536, 28, true}},
+		{hdr(magic, 512, 32), &header{512, 32, false}},
+		{hdr(magic, 512, 33), nil},
+		{hdr(magic, 1000, 0), nil},
+		{hdr(magic, 256, 0), nil},
+	}
+	for _, test := range tests {
+		h, err := parseHeader(test.in)
+		if test.out == nil {
+			if err == nil {
+				t.Errorf("parseHeader(%x) expected an error", test.in[16:24])
+			}
+		} else if err != nil || *h != *test.out {
+			t.Errorf("parseHeader(%x) expected %+v; got %+v (%v)",
+				test.in[16:24], test.out, h, err)
+		}
+	}
+}
+
+func TestConvert(t *testing.T) {
+	dir, err := ioutil.TempDir("", "sqlite3codec")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	plain := filepath.Join(dir, "plain.db")
+	enc := filepath.Join(dir, "enc.db")
+	rekey := filepath.Join(dir, "rekey.db")
+	dec := filepath.Join(dir, "dec.db")
+	key1, key2 := []byte("aes-hmac::secretkey1"), []byte("aead::secretkey2")
+
+	c, err := sqlite3.Open(plain)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = c.Key("main", nil); err == sqlite3.ErrNoCodec {
+		c.Close()
+		t.Skip("codec support is disabled")
+	}
+	err = c.Exec("PRAGMA auto_vacuum=FULL;" +
+		"CREATE TABLE x(a INTEGER PRIMARY KEY AUTOINCREMENT, b);" +
+		"CREATE INDEX x_b ON x(b);" +
+		"INSERT INTO x(b) VALUES('hello, world');" +
+		"PRAGMA user_version=7;" +
+		"PRAGMA application_id=42")
+	c.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err = convert(plain, nil, enc, key1); err != nil {
+		t.Fatalf("convert(encrypt) unexpected error: %v", err)
+	}
+	if err = convert(enc, key1, rekey, key2); err != nil {
+		t.Fatalf("convert(rekey) unexpected error: %v", err)
+	}
+	if err = convert(rekey, key2, dec, nil); err != nil {
+		t.Fatalf("convert(decrypt) unexpected error: %v", err)
+	}
+	if err = convert(rekey, key2, dec, nil); err == nil {
+		t.Fatalf("convert() expected an error for an existing destination")
+	}
+
+	var out bytes.Buffer
+	if err = verify(&out, plain, nil); err != nil {
+		t.Fatalf("verify(plain) unexpected error: %v\n%s", err, out.String())
+	}
+	if out.String() != "ok\n" {
+		t.Fatalf("verify(plain) expected %q; got %q", "ok\n", out.String())
+	}
+	if err = verify(&out, enc, nil); err == nil {
+		t.Fatalf("verify() expected an error for a missing key")
+	}
+	if err = verify(&out, enc, key1); err != nil {
+		t.Fatalf("verify() unexpected error: %v\n%s", err, out.String())
+	}
+	if err = verify(&out, enc, []byte("aes-hmac::secretkey2")); err == nil {
+		t.Fatalf("verify() expected an error for an invalid key")
+	}
+	if !strings.Contains(out.String(), "page 1:") {
+		t.Fatalf("verify() expected a page 1 failure; got %q", out.String())
+	}
+
+	c, err = sqlite3.Open(dec)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	var b string
+	var v int
+	for _, p := range []struct {
+		name string
+		want int
+	}{{"user_version", 7}, {"application_id", 42}, {"auto_vacuum", 1}} {
+		if err = scanInt(c, "PRAGMA "+p.name, &v); err != nil || v != p.want {
+			t.Fatalf("%s expected %d; got %d (%v)", p.name, p.want, v, err)
+		}
+	}
+	s, err := c.Query("SELECT b FROM x")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	if err = s.Scan(&b); err != nil || b != "hello, world" {
+		t.Fatalf("s.Scan() expected %q; got %q (%v)", "hello, world", b, err)
+	}
+}
+
+// scanInt executes a query that returns a single integer value.
+func scanInt(c *sqlite3.Conn, sql string, v *int) error {
+	s, err := c.Query(sql)
+	if err != nil {
+		return err
+	}
+	defer s.Close()
+	return s.Scan(v)
+}
@@ -0,0 +1,330 @@
+// Copyright 2013 The Go-SQLite Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+/*
+Command sqlite3shell is an interactive SQL shell for databases that use the
+codecs implemented by the github.com/mxk/go-sqlite/sqlite3/codec package, which
+the stock sqlite3 command-line tool cannot open.
+
+Usage:
+
+	sqlite3shell [-key=<key>] [-mode=<mode>] [<db> [<sql>]]
+
+The database is opened in memory if no name is given. If the sql argument is
+given, it is executed and the shell exits. Otherwise, SQL statements and
+dot-commands are read from standard input. Statements may span multiple lines
+and are executed when complete (see sqlite3.Complete). The codec key may also be
+provided via the SQLITE3SHELL_KEY environment variable to keep it out of the
+process list.
+
+Dot-commands:
+
+	.backup ?-key=KEY? ?DB? FILE
+	                         Back up DB (default "main") to FILE, which is
+	                         encoded with KEY, if specified
+	.dump ?TABLE ...?        Write the database contents as SQL statements
+	.exit                    Exit the shell
+	.headers on|off          Turn display of column names on or off
+	.help                    Show the list of dot-commands
+	.key ?DB? KEY            Set the codec key for DB (default "main")
+	.mode MODE               Set the output mode: columns, box, markdown, csv,
+	                         json, or jsonl
+	.nullvalue STRING        Display NULL values as STRING
+	.quit                    Exit the shell
+	.schema ?PATTERN?        Show CREATE statements matching the LIKE pattern
+	.tables ?PATTERN?        List tables and views matching the LIKE pattern
+	.width N                 Truncate values to N characters (0 = no limit)
+*/
+package main
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/mxk/go-sqlite/sqlite3"
+	_ "github.com/mxk/go-sqlite/sqlite3/codec"
+)
+
+// Output modes that are not implemented by sqlite3.Formatter.
+const (
+	modeJSON  = -1 - sqlite3.JSONArray
+	modeJSONL = -1 - sqlite3.JSONLines
+)
+
+// modes maps .mode arguments to output modes.
+var modes = map[string]int{
+	"columns":  sqlite3.FormatColumns,
+	"box":      sqlite3.FormatBox,
+	"markdown": sqlite3.FormatMarkdown,
+	"csv":      sqlite3.FormatCSV,
+	"json":     modeJSON,
+	"jsonl":    modeJSONL,
+}
+
+// errQuit is returned by dot-commands that terminate the shell.
+var errQuit = errors.New("quit")
+
+func main() {
+	key := flag.String("key", os.Getenv("SQLITE3SHELL_KEY"), "codec key")
+	mode := flag.String("mode", "columns", "output mode")
+	flag.Usage = func() {
+		fmt.Fprintln(os.Stderr, "usage: sqlite3shell [-key=<key>] [-mode=<mode>] [<db> [<sql>]]")
+		os.Exit(2)
+	}
+	flag.Parse()
+	if flag.NArg() > 2 {
+		flag.Usage()
+	}
+	name := ":memory:"
+	if flag.NArg() > 0 {
+		name = flag.Arg(0)
+	}
+
+	sh, err := newShell(name, []byte(*key), os.Stdout)
+	if err == nil {
+		if err = sh.setMode(*mode); err == nil {
+			if flag.NArg() == 2 {
+				err = sh.exec(flag.Arg(1))
+			} else {
+				err = sh.run(os.Stdin, os.Stderr, isTerminal(os.Stdin))
+			}
+		}
+		if err2 := sh.c.Close(); err == nil {
+			err = err2
+		}
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "sqlite3shell:", err)
+		os.Exit(1)
+	}
+}
+
+// isTerminal returns true if f is a character device.
+func isTerminal(f *os.File) bool {
+	fi, err := f.Stat()
+	return err == nil && fi.Mode()&os.ModeCharDevice != 0
+}
+
+// shell is the state of an interactive session.
+type shell struct {
+	c    *sqlite3.Conn
+	out  io.Writer
+	mode int
+	fmt  sqlite3.Formatter
+}
+
+// newShell opens database name and sets the codec key, if any.
+func newShell(name string, key []byte, out io.Writer) (*shell, error) {
+	c, err := sqlite3.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	if len(key) > 0 {
+		if err = c.Key("main", key); err != nil {
+			c.Close()
+			return nil, err
+		}
+	}
+	return &shell{c: c, out: out}, nil
+}
+
+// run reads statements and dot-commands from r until EOF or a .quit command.
+// Errors are written to errOut. Prompts are also written to errOut if prompt
+// is true.
+func (sh *shell) run(r io.Reader, errOut io.Writer, prompt bool) error {
+	br := bufio.NewReader(r)
+	var sql string
+	for {
+		if prompt {
+			if sql == "" {
+				fmt.Fprint(errOut, "sqlite> ")
+			} else {
+				fmt.Fprint(errOut, "   ...> ")
+			}
+		}
+		line, err := br.ReadString('\n')
+		if err != nil && err != io.EOF {
+			return err
+		}
+		eof := err == io.EOF
+		if sql == "" && strings.TrimSpace(line) == "" {
+			err = nil
+		} else if sql == "" && strings.HasPrefix(strings.TrimSpace(line), ".") {
+			err = sh.dot(strings.TrimSpace(line))
+		} else if sql += line; sqlite3.Complete(sql) || (eof && sql != "") {
+			err, sql = sh.exec(sql), ""
+		} else {
+			err = nil
+		}
+		if err == errQuit {
+			return nil
+		} else if err != nil {
+			fmt.Fprintln(errOut, "Error:", err)
+		}
+		if eof {
+			if prompt {
+				fmt.Fprintln(errOut)
+			}
+			return nil
+		}
+	}
+}
+
+// exec executes all statements in sql and writes the results to sh.out.
+func (sh *shell) exec(sql string) error {
+	for sql != "" {
+		s, err := sh.c.Prepare(sql)
+		if err != nil {
+			return err
+		}
+		sql = s.Tail
+		if s.Valid() {
+			err = sh.print(s)
+		}
+		if err2 := s.Close(); err == nil {
+			err = err2
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// print executes s and writes the results, if any, to sh.out.
+func (sh *shell) print(s *sqlite3.Stmt) error {
+	switch {
+	case s.NumColumns() == 0:
+		return s.Exec()
+	case sh.mode < 0:
+		return s.WriteJSON(sh.out, -1-sh.mode)
+	}
+	return sh.fmt.Format(sh.out, s)
+}
+
+// dot executes a dot-command.
+func (sh *shell) dot(line string) error {
+	args := strings.Fields(line)
+	cmd, args := args[0], args[1:]
+	switch {
+	case cmd == ".quit" || cmd == ".exit":
+		return errQuit
+	case cmd == ".help":
+		fmt.Fprintln(sh.out, strings.TrimSpace(help))
+	case cmd == ".tables" && len(args) <= 1:
+		return sh.query("SELECT name FROM sqlite_master "+
+			"WHERE type IN ('table','view') AND name NOT LIKE 'sqlite_%' "+
+			"AND name LIKE ? ORDER BY 1", pattern(args))
+	case cmd == ".schema" && len(args) <= 1:
+		return sh.query("SELECT sql||';' FROM sqlite_master "+
+			"WHERE sql NOT NULL AND tbl_name LIKE ? "+
+			"ORDER BY type!='table', rowid", pattern(args))
+	case cmd == ".dump":
+		return sh.c.Dump(sh.out, "main", &sqlite3.DumpOptions{Tables: args})
+	case cmd == ".backup" && len(args) >= 1 && len(args) <= 3:
+		opts := new(sqlite3.BackupOptions)
+		if strings.HasPrefix(args[0], "-key=") {
+			opts.Key, args = []byte(args[0][len("-key="):]), args[1:]
+		}
+		switch len(args) {
+		case 2:
+			opts.SrcName, args = args[0], args[1:]
+		case 1:
+		default:
+			return fmt.Errorf("invalid arguments: %q (enter \".help\" for help)", line)
+		}
+		return sh.c.BackupToFile(context.Background(), args[0], opts)
+	case cmd == ".key" && (len(args) == 1 || len(args) == 2):
+		db := "main"
+		if len(args) == 2 {
+			db, args = args[0], args[1:]
+		}
+		return sh.c.Key(db, []byte(args[0]))
+	case cmd == ".mode" && len(args) == 1:
+		return sh.setMode(args[0])
+	case cmd == ".headers" && len(args) == 1 && (args[0] == "on" || args[0] == "off"):
+		sh.fmt.NoHeader = args[0] == "off"
+	case cmd == ".nullvalue" && len(args) <= 1:
+		sh.fmt.Null = strings.Join(args, "")
+	case cmd == ".width" && len(args) == 1:
+		n, err := strconv.Atoi(args[0])
+		if err != nil || n < 0 {
+			return fmt.Errorf("invalid width: %s", args[0])
+		}
+		sh.fmt.MaxWidth = n
+	default:
+		return fmt.Errorf("unknown command or invalid arguments: %q "+
+			"(enter \".help\" for help)", line)
+	}
+	return nil
+}
+
+// setMode changes the output mode.
+func (sh *shell) setMode(name string) error {
+	mode, ok := modes[name]
+	if !ok {
+		return fmt.Errorf("invalid mode: %s", name)
+	}
+	sh.mode = mode
+	if mode >= 0 {
+		sh.fmt.Mode = mode
+	}
+	return nil
+}
+
+// query executes a dot-command query and writes each value on a separate line.
+func (sh *shell) query(sql string, args ...interface{}) error {
+	s, err := sh.c.Query(sql, args...)
+	if err != nil {
+		if err == io.EOF {
+			err = nil
+		}
+		return err
+	}
+	defer s.Close()
+	var v sqlite3.RawString
+	for ; err == nil; err = s.Next() {
+		if err = s.Scan(&v); err != nil {
+			return err
+		}
+		fmt.Fprintln(sh.out, v)
+	}
+	if err == io.EOF {
+		err = nil
+	}
+	return err
+}
+
+// pattern returns the LIKE pattern for .tables and .schema commands.
+func pattern(args []string) string {
+	if len(args) == 0 {
+		return "%"
+	}
+	return args[0]
+}
+
+// help is the output of the .help command.
+const help = `
+.backup ?-key=KEY? ?DB? FILE
+                         Back up DB (default "main") to FILE, encoded with KEY
+.dump ?TABLE ...?        Write the database contents as SQL statements
+.exit                    Exit the shell
+.headers on|off          Turn display of column names on or off
+.help                    Show this message
+.key ?DB? KEY            Set the codec key for DB (default "main")
+.mode MODE               Set the output mode (columns, box, markdown, csv,
+                         json, or jsonl)
+.nullvalue STRING        Display NULL values as STRING
+.quit                    Exit the shell
+.schema ?PATTERN?        Show CREATE statements matching the LIKE pattern
+.tables ?PATTERN?        List tables and views matching the LIKE pattern
+.width N                 Truncate values to N characters (0 = no limit)
+`
@@ -0,0 +1,95 @@
+// Copyright 2013 The Go-SQLite Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bytes"
+	"path/filepath"
+	"testing"
+
+	"github.com/mxk/go-sqlite/sqlite3"
+)
+
+func TestShell(t *testing.T) {
+	var out, errOut bytes.Buffer
+	sh, err := newShell(":memory:", nil, &out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer sh.c.Close()
+
+	in := `
+CREATE TABLE x(a, b);
+INSERT INTO x VALUES(1, 'one'),
+                    (2, NULL);
+.tables
+.nullvalue -
+SELECT * FROM x; SELECT count(*) AS n FROM x;
+.mode jsonl
+SELECT a FROM x
+  WHERE b IS NULL;
+.mode csv
+.headers off
+SELECT * FROM x WHERE a=1;
+.bogus
+SELECT * FROM missing;
+.quit
+SELECT 'not executed';
+`
+	if err = sh.run(bytes.NewBufferString(in), &errOut, false); err != nil {
+		t.Fatal(err)
+	}
+	want := "x\n" +
+		"a  b\n" +
+		"-  ---\n" +
+		"1  one\n" +
+		"2  -\n" +
+		"n\n" +
+		"-\n" +
+		"2\n" +
+		"{\"a\":2}\n" +
+		"1,one\n"
+	if out.String() != want {
+		t.Errorf("output expected:\n%s\ngot:\n%s", want, out.String())
+	}
+	if n := bytes.Count(errOut.Bytes(), []byte("Error:")); n != 2 {
+		t.Errorf("expected 2 errors; got:\n%s", errOut.String())
+	}
+}
+
+func TestBackup(t *testing.T) {
+	var out, errOut bytes.Buffer
+	sh, err := newShell(":memory:", nil, &out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer sh.c.Close()
+
+	file := filepath.Join(t.TempDir(), "backup.db")
+	in := "CREATE TABLE x(a);\n" +
+		"INSERT INTO x VALUES(42);\n" +
+		".backup main " + file + "\n" +
+		".backup main " + file + " key\n"
+	if err = sh.run(bytes.NewBufferString(in), &errOut, false); err != nil {
+		t.Fatal(err)
+	}
+	if n := bytes.Count(errOut.Bytes(), []byte("Error: invalid arguments")); n != 1 {
+		t.Errorf("expected 1 invalid arguments error; got:\n%s", errOut.String())
+	}
+	c, err := sqlite3.Open(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	s, err := c.Query("SELECT a FROM x")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	var a int
+	if err = s.Scan(&a); err != nil || a != 42 {
+		t.Errorf("backup expected 42; got %d (%v)", a, err)
+	}
+}
@@ -0,0 +1,7 @@
+module github.com/mxk/go-sqlite
+
+go 1.17
+
+require golang.org/x/crypto v0.14.0
+
+require golang.org/x/sys v0.13.0 // indirect
@@ -6,12 +6,29 @@ package sqlite3
 
 /*
 #include "sqlite3.h"
+
+// Wrapper for codec_page_info that allows codec support to be disabled.
+static int page_info(sqlite3 *db, const char *zDb, int *nBuf, int *nRes, int *hasCodec, int *loaded) {
+#ifdef SQLITE_HAS_CODEC
+	return codec_page_info(db, zDb, nBuf, nRes, hasCodec, loaded);
+#else
+	return -1;
+#endif
+}
 */
 import "C"
 
 import (
+	"context"
+	"errors"
+	"fmt"
 	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
 	"runtime"
+	"strings"
+	"time"
 )
 
 // Backup is a handle to an online backup operation between two databases.
@@ -25,6 +42,9 @@ type Backup struct {
 // newBackup initializes an online backup operation from src.srcName to
 // dst.dstName.
 func newBackup(src *Conn, srcName string, dst *Conn, dstName string) (*Backup, error) {
+	if err := prepareBackup(src, srcName, dst, dstName); err != nil {
+		return nil, err
+	}
 	srcName += "\x00"
 	dstName += "\x00"
 
@@ -34,10 +54,109 @@ func newBackup(src *Conn, srcName string, dst *Conn, dstName string) (*Backup, e
 	}
 
 	b := &Backup{src, dst, bkup}
+	src.ref()
 	runtime.SetFinalizer(b, (*Backup).Close)
 	return b, nil
 }
 
+// prepareBackup sets the page size of a destination database that has a codec
+// attached to the page size of the source, and verifies that both databases
+// reserve the same number of bytes in each page. Without this, SQLite fails the
+// first call to Backup.Step with a READONLY error code, because pages cannot be
+// copied between databases with different layouts when a codec is in use. The
+// reserve value of the destination is determined by its codec. The returned
+// error wraps errLayout if the layouts cannot be made compatible.
+func prepareBackup(src *Conn, srcName string, dst *Conn, dstName string) error {
+	srcName, dstName = dbName(srcName), dbName(dstName)
+	d, ok := pageInfo(dst, dstName)
+	if !ok || !d.codec {
+		return nil
+	}
+
+	// Page size and reserve values are not known until the schema is loaded
+	s, _ := pageInfo(src, srcName)
+	var err error
+	if !s.loaded {
+		err = src.checkKey(srcName)
+	}
+	if err == nil && !d.loaded {
+		err = dst.checkKey(dstName)
+	}
+	if err != nil {
+		if rc := errCode(err) & 0xff; rc == BUSY || rc == LOCKED {
+			err = nil // Let Backup.Step handle it
+		}
+		return err
+	}
+	s, _ = pageInfo(src, srcName)
+	d, _ = pageInfo(dst, dstName)
+	if s.reserve != d.reserve {
+		return layoutErr("backup destination %q requires %d reserved byte(s) "+
+			"per page, source %q has %d", dstName, d.reserve, srcName,
+			s.reserve)
+	}
+	if s.size != d.size {
+		n, err := pageSize(dst, dstName, s.size)
+		if err != nil {
+			return err
+		}
+		if n != s.size {
+			return layoutErr("page size of backup destination %q cannot be "+
+				"changed from %d to %d", dstName, n, s.size)
+		}
+	}
+	return nil
+}
+
+// errLayout is wrapped by errors returned from prepareBackup when the page
+// layouts of the source and destination databases are incompatible.
+var errLayout = errors.New("incompatible page layouts")
+
+// layoutErr returns a READONLY error that wraps errLayout.
+func layoutErr(msg string, v ...interface{}) error {
+	return &Error{rc: READONLY, msg: fmt.Sprintf(msg, v...), err: errLayout}
+}
+
+// pageLayout describes the pages of an attached database.
+type pageLayout struct {
+	size    int  // Page size in bytes
+	reserve int  // Number of bytes reserved at the end of each page
+	codec   bool // Database has a codec attached
+	loaded  bool // Schema is loaded, so size and reserve are up to date
+}
+
+// pageInfo returns the page layout of database name. It returns false if the
+// information is not available, which is always the case without codec
+// support.
+func pageInfo(c *Conn, name string) (l pageLayout, ok bool) {
+	name += "\x00"
+	var nBuf, nRes, hasCodec, loaded C.int
+	if C.page_info(c.db, cStr(name), &nBuf, &nRes, &hasCodec, &loaded) != OK {
+		return l, false
+	}
+	return pageLayout{int(nBuf), int(nRes), hasCodec != 0, loaded != 0}, true
+}
+
+// pageSize sets the page size of database name and returns the new value.
+func pageSize(c *Conn, name string, n int) (int, error) {
+	sql := fmt.Sprintf("PRAGMA %s.page_size=%d", quoteIdent(name), n)
+	if err := c.Exec(sql); err != nil {
+		return 0, err
+	}
+	return pragmaInt(c, name, "page_size")
+}
+
+// pragmaInt returns the integer value of pragma name for database db.
+func pragmaInt(c *Conn, db, name string) (n int, err error) {
+	s, err := c.Query("PRAGMA " + quoteIdent(db) + "." + name)
+	if err != nil {
+		return 0, err
+	}
+	defer s.Close()
+	err = s.Scan(&n)
+	return n, err
+}
+
 // Close releases all resources associated with the backup operation. It is safe
 // to call this method prior to backup completion to abort the operation.
 // [http://www.sqlite.org/c3ref/backup_finish.html#sqlite3backupfinish]
@@ -45,7 +164,9 @@ func (b *Backup) Close() error {
 	if bkup := b.bkup; bkup != nil {
 		b.bkup = nil
 		runtime.SetFinalizer(b, nil)
-		if rc := C.sqlite3_backup_finish(bkup); rc != OK {
+		rc := C.sqlite3_backup_finish(bkup)
+		b.src.unref()
+		if rc != OK {
 			return libErr(rc, b.dst.db)
 		}
 	}
@@ -90,3 +211,238 @@ func (b *Backup) Progress() (remaining, total int) {
 	}
 	return
 }
+
+// backupRetryDelay is the time to wait before the first retry of a step that
+// failed with BUSY or LOCKED error when BackupOptions.Delay is not set. The
+// delay is doubled after each consecutive failure, up to backupMaxRetryDelay.
+const (
+	backupRetryDelay    = 100 * time.Millisecond
+	backupMaxRetryDelay = 2 * time.Second
+)
+
+// BackupOptions controls the behavior of Conn.BackupTo and Conn.BackupToFile.
+// The zero value copies the main database in a single step.
+type BackupOptions struct {
+	SrcName string // Source database name (default "main")
+	DstName string // Destination database name (default "main")
+
+	// PagesPerStep is the number of pages copied by each call to Backup.Step.
+	// All pages are copied in one step if the value is zero or negative.
+	// Smaller values allow other connections to access the source database
+	// between steps.
+	PagesPerStep int
+
+	// Delay is the time to wait between steps. It is also the initial time to
+	// wait before retrying a step that failed with BUSY or LOCKED error code
+	// (100ms if Delay is zero), which is doubled after each consecutive failure
+	// up to a maximum of 2s or Delay, whichever is greater.
+	Delay time.Duration
+
+	// Progress, if not nil, is called after each successful step with the
+	// number of pages that still need to be copied and the total number of
+	// pages in the source database.
+	Progress func(remaining, total int)
+
+	// Key is the codec key for the destination database created by
+	// Conn.BackupToFile. It is not used by Conn.BackupTo.
+	Key []byte
+}
+
+// BackupTo performs a complete online backup of the source database into dst.
+// Steps that fail with BUSY or LOCKED error codes are retried with exponential
+// backoff until ctx is done, so a context without a deadline or cancellation
+// waits for as long as the database remains locked. If ctx is done before the
+// backup is finished, the backup is aborted and ctx.Err() is returned. See
+// Conn.Backup for additional information.
+//
+// If the page layouts of the two databases are incompatible because of their
+// codecs (see "Codecs and Encryption" in the package documentation), and the
+// destination is a new main database without any pages, the schema and
+// contents are copied with Dump and Restore instead, followed by the page_size,
+// auto_vacuum, user_version, and application_id values. This has the same
+// effect as VACUUM (ROWIDs of tables without an INTEGER PRIMARY KEY may
+// change), and the PagesPerStep, Delay, and Progress options are not used. It
+// allows a plaintext database to be encrypted and the codec of a database to be
+// changed.
+func (c *Conn) BackupTo(ctx context.Context, dst *Conn, opts *BackupOptions) error {
+	if opts == nil {
+		opts = new(BackupOptions)
+	}
+	b, err := c.Backup(dbName(opts.SrcName), dst, dbName(opts.DstName))
+	if err != nil {
+		if errors.Is(err, errLayout) {
+			return c.copyTo(ctx, dbName(opts.SrcName), dst, dbName(opts.DstName), err)
+		}
+		return err
+	}
+	n := opts.PagesPerStep
+	if n <= 0 {
+		n = -1
+	}
+	retry, maxRetry := opts.Delay, backupMaxRetryDelay
+	if retry <= 0 {
+		retry = backupRetryDelay
+	}
+	if maxRetry < retry {
+		maxRetry = retry
+	}
+	for next := retry; ; {
+		delay := opts.Delay
+		if err = b.Step(n); err == nil || err == io.EOF {
+			if opts.Progress != nil {
+				opts.Progress(b.Progress())
+			}
+			if err == io.EOF {
+				return b.Close()
+			}
+			next = retry
+		} else if rc := errCode(err) & 0xff; rc == BUSY || rc == LOCKED {
+			if delay, next = next, 2*next; next > maxRetry {
+				next = maxRetry
+			}
+		} else {
+			b.Close()
+			return err
+		}
+		if err = sleep(ctx, delay); err != nil {
+			b.Close()
+			return err
+		}
+	}
+}
+
+// copyTo copies database srcName into the main database of dst by executing
+// the output of Dump with Restore. If dst is not a new main database, which
+// does not have any pages yet, orig is returned instead.
+func (c *Conn) copyTo(ctx context.Context, srcName string, dst *Conn, dstName string, orig error) error {
+	if c == dst || dstName != "main" {
+		return orig
+	}
+	if n, err := pragmaInt(dst, "main", "page_count"); err != nil || n > 0 {
+		return orig
+	}
+
+	// Header values that are not included in the output of Dump
+	hdr := [...]string{"page_size", "auto_vacuum", "user_version", "application_id"}
+	var v [len(hdr)]int
+	for i, name := range hdr {
+		var err error
+		if v[i], err = pragmaInt(c, srcName, name); err != nil {
+			return err
+		}
+	}
+	for i, name := range hdr[:2] {
+		if err := dst.Exec(fmt.Sprintf("PRAGMA main.%s=%d", name, v[i])); err != nil {
+			return err
+		}
+	}
+	post := fmt.Sprintf("PRAGMA main.user_version=%d;\nPRAGMA main.application_id=%d;\n",
+		v[2], v[3])
+
+	// Dump runs in another goroutine, which has exclusive use of c until done
+	pr, pw := io.Pipe()
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		pw.CloseWithError(c.Dump(pw, srcName, nil))
+	}()
+	err := dst.Restore(io.MultiReader(ctxReader{ctx, pr}, strings.NewReader(post)))
+	pr.Close()
+	<-done
+	return err
+}
+
+// ctxReader is an io.Reader that fails with ctx.Err() once ctx is done.
+type ctxReader struct {
+	ctx context.Context
+	r   io.Reader
+}
+
+func (r ctxReader) Read(p []byte) (int, error) {
+	if err := r.ctx.Err(); err != nil {
+		return 0, err
+	}
+	return r.r.Read(p)
+}
+
+// BackupToFile performs a complete online backup of the source database into a
+// new database file. The backup is written to a temporary file in the same
+// directory, which is renamed to path after the backup is finished, replacing
+// any existing file. The new file has the permissions of the file it replaces
+// or 0644 if path does not exist. On Windows, the rename fails if path is open,
+// for example by another connection, and the backup is discarded. The
+// destination database is encoded with opts.Key, if one is specified. See
+// Conn.BackupTo for additional information.
+func (c *Conn) BackupToFile(ctx context.Context, path string, opts *BackupOptions) error {
+	if c.db == nil {
+		return ErrBadConn
+	}
+	f, err := ioutil.TempFile(filepath.Dir(path), filepath.Base(path)+".tmp")
+	if err != nil {
+		return err
+	}
+	tmp := f.Name()
+	defer os.Remove(tmp)
+	mode := os.FileMode(0644)
+	if fi, err := os.Stat(path); err == nil {
+		mode = fi.Mode().Perm()
+	}
+	err = f.Chmod(mode)
+	if err2 := f.Close(); err == nil {
+		err = err2
+	}
+	if err != nil {
+		return err
+	}
+
+	dst, err := open(tmp, "")
+	if err != nil {
+		return err
+	}
+	if opts == nil {
+		opts = new(BackupOptions)
+	} else if len(opts.Key) > 0 {
+		err = dst.Key("main", opts.Key)
+	}
+	if err == nil {
+		o := *opts
+		o.DstName = "main"
+		err = c.BackupTo(ctx, dst, &o)
+	}
+	if err2 := dst.Close(); err == nil {
+		err = err2
+	}
+	if err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+// dbName returns name or "main" if name is empty.
+func dbName(name string) string {
+	if name == "" {
+		return "main"
+	}
+	return name
+}
+
+// sleep waits for duration d or until ctx is done, whichever happens first. It
+// returns ctx.Err() if ctx is done.
+func sleep(ctx context.Context, d time.Duration) error {
+	if d <= 0 {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		default:
+			return nil
+		}
+	}
+	t := time.NewTimer(d)
+	defer t.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-t.C:
+		return nil
+	}
+}
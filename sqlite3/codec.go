@@ -12,6 +12,8 @@ import "C"
 
 import (
 	"bytes"
+	"fmt"
+	"strings"
 	"sync"
 	"unsafe"
 )
@@ -73,14 +75,15 @@ type Codec interface {
 	// area in the original page must not be modified. The codec can either copy
 	// this data into a buffer for encoding or return the original page without
 	// making any changes. Bytes 16 through 23 of page 1 cannot be encoded. Any
-	// non-nil error will be interpreted by SQLite as a NOMEM condition. This is
-	// a limitation of underlying C API.
+	// non-nil error aborts the current operation. SQLite interprets it as a
+	// NOMEM condition, but the error is translated back before it is returned
+	// to the caller (see Conn.CodecError).
 	Encode(page []byte, pageNum uint32, op int) ([]byte, *Error)
 
 	// Decode decodes the page in-place, but it may use the encode buffer as
 	// scratch space. Bytes 16 through 23 of page 1 must be left at their
-	// original values. Any non-nil error will be interpreted by SQLite as a
-	// NOMEM condition. This is a limitation of underlying C API.
+	// original values. Any non-nil error aborts the current operation, as
+	// described for Encode.
 	Decode(page []byte, pageNum uint32, op int) *Error
 
 	// Key returns the original key that was used to initialize the codec. Some
@@ -103,6 +106,16 @@ var (
 // RegisterCodec adds a new codec to the internal registry. Function f will be
 // called when a key in the format "<name>:<...>" is provided to an attached
 // database.
+//
+// Registered codecs may be chained by joining their names with '+' (e.g.
+// "compress+aes-hmac:<...>"). Pages are encoded by each codec in the order
+// listed and decoded in reverse. Each codec operates on a page that excludes
+// the space reserved by the codecs that follow it. The last codec in the chain
+// receives the unqualified options and the remainder of the key. An option may
+// be given to another codec by qualifying it with the codec name (e.g.
+// "compress+aes-hmac:compress.level=9,hmac=sha256:<...>"), in which case that
+// codec is initialized with the key "compress:level=9". Codecs in a chain must
+// not return -1 from Reserve.
 func RegisterCodec(name string, f CodecFunc) {
 	codecMu.Lock()
 	defer codecMu.Unlock()
@@ -116,6 +129,26 @@ func RegisterCodec(name string, f CodecFunc) {
 	codecReg[name] = f
 }
 
+// NewCodec initializes the registered codec that matches key without attaching
+// it to a database. This allows tools to encode and decode database pages
+// directly. The caller must call Codec.Resize before using the codec and
+// Codec.Free when it is no longer needed. A nil Codec and error are returned if
+// the codec was disabled by its CodecFunc.
+func NewCodec(ctx *CodecCtx, key []byte) (Codec, error) {
+	cf := getCodec(key)
+	if cf == nil {
+		return nil, pkgErr(ERROR, "codec not found")
+	}
+	ci, err := cf(ctx, append([]byte(nil), key...))
+	if err != nil && err.rc != OK {
+		if ci != nil {
+			ci.Free()
+		}
+		return nil, err
+	}
+	return ci, nil
+}
+
 // getCodec returns the CodecFunc for the given key.
 func getCodec(key []byte) CodecFunc {
 	i := bytes.IndexByte(key, ':')
@@ -127,14 +160,242 @@ func getCodec(key []byte) CodecFunc {
 	if codecReg == nil {
 		return nil
 	}
-	return codecReg[bstr(key[:i])]
+	name := bstr(key[:i])
+	if cf := codecReg[name]; cf != nil || strings.IndexByte(name, '+') == -1 {
+		return cf
+	}
+	names := strings.Split(name, "+")
+	funcs := make([]CodecFunc, len(names))
+	for i, name := range names {
+		if funcs[i] = codecReg[name]; funcs[i] == nil {
+			return nil
+		}
+	}
+	return newChainFunc(names, funcs)
+}
+
+// chain is a Codec that applies multiple codecs to each page.
+type chain struct {
+	name   string  // Chain name (e.g. "compress+aes-hmac")
+	opts   string  // Qualified options of all but the last codec
+	codecs []Codec // Codecs in encoding order
+	names  []string
+	res    []int  // Space reserved by each codec
+	size   []int  // Page size seen by each codec
+	buf    []byte // Page encoding buffer
+}
+
+// newChainFunc returns a CodecFunc that initializes a chain of codecs.
+func newChainFunc(names []string, funcs []CodecFunc) CodecFunc {
+	return func(ctx *CodecCtx, key []byte) (Codec, *Error) {
+		k := bytes.SplitN(key, []byte{':'}, 3)
+		c := &chain{name: string(k[0])}
+		last := len(funcs) - 1
+		opts := make([][]string, len(funcs))
+		var qual []string
+		if len(k) > 1 && len(k[1]) > 0 {
+			for _, opt := range strings.Split(string(k[1]), ",") {
+				i := strings.IndexAny(opt, ".=")
+				if i <= 0 || opt[i] != '.' {
+					opts[last] = append(opts[last], opt)
+					continue
+				}
+				found := false
+				for j, name := range names {
+					if name == opt[:i] {
+						opts[j] = append(opts[j], opt[i+1:])
+						found = true
+					}
+				}
+				if !found {
+					return nil, NewError(MISUSE, "invalid codec option: "+opt+
+						" (no "+opt[:i]+" codec in "+c.name+")")
+				}
+				if opt[:i] != names[last] {
+					qual = append(qual, opt)
+				}
+			}
+		}
+		c.opts = strings.Join(qual, ",")
+		for j, f := range funcs {
+			ck := []byte(names[j])
+			if len(opts[j]) > 0 || (j == last && len(k) > 1) {
+				ck = append(ck, ':')
+				ck = append(ck, strings.Join(opts[j], ",")...)
+			}
+			if j == last && len(k) > 2 {
+				ck = append(ck, ':')
+				ck = append(ck, k[2]...)
+				for n := len(k[0]); n < len(key); n++ {
+					key[n] = 0 // Only the last codec gets to see the secret
+				}
+			}
+			ci, err := f(ctx, ck)
+			if err != nil && err.rc != OK {
+				if ci != nil {
+					ci.Free()
+				}
+				c.Free()
+				return nil, err
+			}
+			if ci == nil {
+				continue
+			}
+			c.codecs = append(c.codecs, ci)
+			c.names = append(c.names, names[j])
+			n := ci.Reserve()
+			c.res = append(c.res, n)
+			if n < 0 {
+				c.Free()
+				return nil, NewError(MISUSE, "codec "+names[j]+
+					" must reserve a fixed number of bytes in a chain")
+			}
+		}
+		if len(c.codecs) == 0 {
+			return nil, nil
+		}
+		return c, nil
+	}
+}
+
+func (c *chain) Reserve() int {
+	total := 0
+	for _, n := range c.res {
+		total += n
+	}
+	return total
+}
+
+func (c *chain) Resize(pageSize, reserve int) {
+	if reserve != c.Reserve() {
+		panic("sqlite3: codec reserve value mismatch")
+	}
+	c.size = make([]int, len(c.codecs))
+	for i := len(c.codecs) - 1; i >= 0; i-- {
+		c.size[i] = pageSize
+		c.codecs[i].Resize(pageSize, c.res[i])
+		pageSize -= c.res[i]
+	}
+	c.buf = make([]byte, c.size[len(c.size)-1])
+}
+
+func (c *chain) Encode(p []byte, n uint32, op int) ([]byte, *Error) {
+	last := len(c.codecs) - 1
+	for i, ci := range c.codecs {
+		out, err := ci.Encode(p[:c.size[i]], n, op)
+		if err != nil || i == last {
+			return out, err
+		}
+		copy(c.buf, out)
+		p = c.buf
+	}
+	return p, nil
+}
+
+func (c *chain) Decode(p []byte, n uint32, op int) *Error {
+	for i := len(c.codecs) - 1; i >= 0; i-- {
+		if err := c.codecs[i].Decode(p[:c.size[i]], n, op); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (c *chain) Key() []byte {
+	last := len(c.codecs) - 1
+	if c.names[last] != c.name[len(c.name)-len(c.names[last]):] {
+		return nil // Last codec in the chain was disabled
+	}
+	key := c.codecs[last].Key()
+	if len(key) < len(c.names[last]) {
+		return nil
+	}
+	key = key[len(c.names[last]):] // ":<options>:<...>" or ""
+	if c.opts == "" {
+		return append([]byte(c.name), key...)
+	}
+	k := append([]byte(c.name), ':')
+	k = append(k, c.opts...)
+	if len(key) > 1 && key[1] != ':' {
+		k = append(k, ',')
+	}
+	return append(k, bytes.TrimPrefix(key, []byte{':'})...)
+}
+
+func (c *chain) Free() {
+	for _, ci := range c.codecs {
+		ci.Free()
+	}
+	*c = chain{}
 }
 
 // codec is a wrapper around the actual Codec interface. It keeps track of the
-// current page size in order to convert page pointers into byte slices.
+// current page size in order to convert page pointers into byte slices, and of
+// the last Encode or Decode error, which SQLite reports as NOMEM.
 type codec struct {
 	Codec
 	pageSize C.int
+
+	db      *C.sqlite3 // Connection that owns the pager
+	name    string     // Database name as it was known to SQLite when attached
+	err     *Error     // Last Encode or Decode error
+	pending bool       // Flag indicating that err was not reported yet
+}
+
+// fail records an error returned by Encode or Decode. Generic ERROR codes from
+// Decode are converted to NOTADB for page 1, which usually indicates an invalid
+// key, and to CORRUPT for all other pages.
+func (cs *codec) fail(err *Error, pgno uint32, encode bool) {
+	op, rc := "decode", err.rc
+	if encode {
+		op = "encode"
+	} else if rc == OK || rc == ERROR {
+		if rc = CORRUPT; pgno == 1 {
+			rc = NOTADB
+		}
+	}
+	if rc == OK {
+		rc = ERROR
+	}
+	msg := fmt.Sprintf("codec failed to %s page %d of %q database", op, pgno,
+		cs.name)
+	if rc == NOTADB {
+		msg = fmt.Sprintf("invalid key for %q database (%s)", cs.name, msg)
+	}
+	if err.msg != "" {
+		msg += ": " + err.msg
+	}
+	codecMu.Lock()
+	defer codecMu.Unlock()
+	cs.err = &Error{rc: rc, msg: msg, err: err}
+	cs.pending = true
+}
+
+// codecErr returns the unreported codec error for database connection db, if
+// there is one. It is used to replace the NOMEM result code that SQLite returns
+// when a codec fails.
+func codecErr(db *C.sqlite3) *Error {
+	codecMu.Lock()
+	defer codecMu.Unlock()
+	for cs := range codecState {
+		if cs.db == db && cs.pending {
+			cs.pending = false
+			return cs.err
+		}
+	}
+	return nil
+}
+
+// lastCodecErr returns the last codec error for the specified database.
+func lastCodecErr(db *C.sqlite3, name string) *Error {
+	codecMu.Lock()
+	defer codecMu.Unlock()
+	for cs := range codecState {
+		if cs.db == db && cs.name == name {
+			return cs.err
+		}
+	}
+	return nil
 }
 
 //export go_codec_init
@@ -155,7 +416,8 @@ func go_codec_init(ctx *C.CodecCtx, pCodec *unsafe.Pointer, pzErrMsg **C.char) C
 		return C.int(err.rc)
 	}
 	if ci != nil {
-		cs := &codec{ci, ctx.nBuf}
+		cs := &codec{Codec: ci, pageSize: ctx.nBuf, db: ctx.db,
+			name: C.GoString(ctx.zName)}
 		*pCodec = unsafe.Pointer(cs)
 		codecMu.Lock()
 		defer codecMu.Unlock()
@@ -192,7 +454,8 @@ func go_codec_exec(pCodec, pData unsafe.Pointer, pgno uint32, op C.int) unsafe.P
 	if err == nil {
 		return cBytes(page)
 	}
-	return nil // Can't do anything with the error at the moment
+	cs.fail(err, pgno, op&4 != 0)
+	return nil
 }
 
 //export go_codec_get_key
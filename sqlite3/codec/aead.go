@@ -0,0 +1,194 @@
+// Copyright 2013 The Go-SQLite Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package codec
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/sha256"
+
+	"golang.org/x/crypto/chacha20poly1305"
+
+	. "github.com/mxk/go-sqlite/sqlite3"
+)
+
+// Nonce and tag sizes shared by all supported AEAD ciphers.
+const (
+	aeadNonceLen = 12
+	aeadTagLen   = 16
+)
+
+type aeadCodec struct {
+	key   []byte   // Key provided to newAEAD with the master key removed
+	buf   []byte   // Page encryption buffer
+	tmp   []byte   // Scratch buffer for page 1, which has an unencrypted gap
+	hdr   [12]byte // Additional data (page number and page 1 header bytes)
+	nonce [aeadNonceLen]byte
+
+	// Cipher initialized from the master key
+	aead cipher.AEAD
+}
+
+func newAEAD(ctx *CodecCtx, key []byte) (Codec, *Error) {
+	name, opts, mk := parseKey(key)
+	if len(mk) == 0 {
+		return nil, keyErr
+	}
+	defer wipe(mk)
+
+	// Configure the codec
+	c := &aeadCodec{key: key[:len(key)-len(mk)]}
+	suite := suiteId{
+		Cipher:  "aes",
+		KeySize: "128",
+		Mode:    "gcm",
+	}
+	kLen := 16
+	if err := c.config(opts, &suite, &kLen); err != nil {
+		return nil, err
+	}
+
+	// Derive the encryption key
+	salt := make([]byte, sha256.Size)
+	copy(salt, name)
+	dk := hkdf(mk, salt, kLen, sha256.New)(suite.Id())
+	defer wipe(dk)
+
+	// Initialize the cipher
+	var err error
+	if suite.Cipher == "chacha20" {
+		c.aead, err = chacha20poly1305.New(dk)
+	} else {
+		var block cipher.Block
+		if block, err = aes.NewCipher(dk); err == nil {
+			c.aead, err = cipher.NewGCM(block)
+		}
+	}
+	if err != nil {
+		return nil, NewError(MISUSE, err.Error())
+	}
+	return c, nil
+}
+
+func (c *aeadCodec) Reserve() int {
+	return aeadNonceLen + aeadTagLen
+}
+
+func (c *aeadCodec) Resize(pageSize, reserve int) {
+	if reserve != c.Reserve() {
+		panic("sqlite3: codec reserve value mismatch")
+	}
+	c.buf = make([]byte, pageSize)
+	c.tmp = make([]byte, pageSize)
+}
+
+func (c *aeadCodec) Encode(p []byte, n uint32, op int) ([]byte, *Error) {
+	nonce := c.pNonce(c.buf)
+	if !rnd(nonce) {
+		return nil, prngErr
+	}
+	ad := c.ad(p, n)
+	if n != 1 {
+		c.aead.Seal(c.buf[:0], nonce, c.pText(p), ad)
+		return c.buf, nil
+	}
+	// Bytes 16 through 23 of page 1 are authenticated as additional data and
+	// stored without encryption. The rest of the page is sealed as one message.
+	pt := c.tmp[:len(c.pText(p))-8]
+	copy(pt, p[:16])
+	copy(pt[16:], p[24:len(c.pText(p))])
+	ct := c.aead.Seal(pt[:0], nonce, pt, ad)
+	copy(c.buf, ct[:16])
+	copy(c.buf[16:], p[16:24])
+	copy(c.buf[24:], ct[16:])
+	return c.buf, nil
+}
+
+func (c *aeadCodec) Decode(p []byte, n uint32, op int) *Error {
+	copy(c.nonce[:], c.pNonce(p))
+	ad := c.ad(p, n)
+	sealed := p[:len(p)-aeadNonceLen]
+	if n != 1 {
+		if _, err := c.aead.Open(sealed[:0], c.nonce[:], sealed, ad); err != nil {
+			return codecErr
+		}
+		return nil
+	}
+	ct := c.tmp[:len(sealed)-8]
+	copy(ct, p[:16])
+	copy(ct[16:], p[24:len(sealed)])
+	pt, err := c.aead.Open(ct[:0], c.nonce[:], ct, ad)
+	if err != nil {
+		return codecErr
+	}
+	copy(p, pt[:16])
+	copy(p[24:], pt[16:])
+	return nil
+}
+
+func (c *aeadCodec) Key() []byte {
+	return c.key
+}
+
+func (c *aeadCodec) Free() {
+	c.buf = nil
+	c.tmp = nil
+	c.aead = nil
+}
+
+// config applies the codec options that were provided in the key.
+func (c *aeadCodec) config(opts map[string]string, s *suiteId, kLen *int) *Error {
+	sized := false
+	for k := range opts {
+		switch k {
+		case "192":
+			s.KeySize = k
+			*kLen = 24
+			sized = true
+		case "256":
+			s.KeySize = k
+			*kLen = 32
+			sized = true
+		case "chacha20":
+			s.Cipher = k
+			s.Mode = "poly1305"
+		default:
+			return NewError(MISUSE, "invalid codec option: "+k)
+		}
+	}
+	if s.Cipher == "chacha20" {
+		// ChaCha20 has a fixed key size
+		if sized {
+			return NewError(MISUSE, "invalid codec option: "+s.KeySize)
+		}
+		s.KeySize = ""
+		*kLen = chacha20poly1305.KeySize
+	}
+	return nil
+}
+
+// ad returns the additional data for page p, which binds the ciphertext to the
+// page number. Page 1 also includes the unencrypted header bytes.
+func (c *aeadCodec) ad(p []byte, n uint32) []byte {
+	c.hdr[0] = byte(n >> 24)
+	c.hdr[1] = byte(n >> 16)
+	c.hdr[2] = byte(n >> 8)
+	c.hdr[3] = byte(n)
+	if n == 1 {
+		copy(c.hdr[4:], p[16:24])
+		return c.hdr[:]
+	}
+	return c.hdr[:4]
+}
+
+// pText returns the page subslice that gets encrypted.
+func (c *aeadCodec) pText(p []byte) []byte {
+	return p[:len(p)-aeadTagLen-aeadNonceLen]
+}
+
+// pNonce returns the page nonce.
+func (c *aeadCodec) pNonce(p []byte) []byte {
+	return p[len(p)-aeadNonceLen:]
+}
@@ -17,6 +17,8 @@ import (
 
 func init() {
 	RegisterCodec("aes-hmac", newAesHmac)
+	RegisterCodec("aead", newAEAD)
+	RegisterCodec("compress", newCompress)
 	RegisterCodec("hexdump", newHexDump)
 }
 
@@ -5,11 +5,18 @@
 package codec
 
 import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
 	"crypto/sha1"
 	"crypto/sha256"
 	"encoding/hex"
 	"hash"
 	"testing"
+
+	"golang.org/x/crypto/chacha20poly1305"
+
+	. "github.com/mxk/go-sqlite/sqlite3"
 )
 
 func TestHKDF(t *testing.T) {
@@ -126,3 +133,187 @@ func TestSuiteId(t *testing.T) {
 		}
 	}
 }
+
+func TestAEAD(t *testing.T) {
+	tests := []struct {
+		key string
+		dk  string
+		new func(key []byte) (cipher.AEAD, error)
+	}{
+		{
+			"aead::secretkey1",
+			"8d46274e3c7ba562375e4542467e2d64",
+			newGCM,
+		}, {
+			"aead:192:secretkey1",
+			"d6920d438cc14bebad6741f56b7bc5e02f3832cfc8b2491e",
+			newGCM,
+		}, {
+			"aead:256:secretkey1",
+			"93abd9020906ca00ced380fb8f3174f399b9e5b60e6c062a8c75a1a5d269d832",
+			newGCM,
+		}, {
+			"aead:chacha20:secretkey1",
+			"fb231f3e820dc2012ea45f01efed32ad539b1d3404b66ab96856fad33aa0e085",
+			chacha20poly1305.New,
+		},
+	}
+	const pageSize = 1024
+	page := make([]byte, pageSize)
+	for i := range page {
+		page[i] = byte(i * 7)
+	}
+	for _, test := range tests {
+		ci, err := newAEAD(&CodecCtx{}, []byte(test.key))
+		if err != nil {
+			t.Fatalf("newAEAD(%q) unexpected error: %v", test.key, err)
+		}
+		c := ci.(*aeadCodec)
+		if c.Reserve() != 28 {
+			t.Fatalf("%q Reserve() expected 28; got %d", test.key, c.Reserve())
+		}
+		c.Resize(pageSize, c.Reserve())
+
+		// Independent implementation using the expected derived key
+		dk, _ := hex.DecodeString(test.dk)
+		ref, _ := test.new(dk)
+		n := pageSize - c.Reserve()
+
+		for _, pgno := range []uint32{1, 2} {
+			p := append([]byte(nil), page...)
+			enc, err := c.Encode(p, pgno, 6)
+			if err != nil {
+				t.Fatalf("%q Encode(%d) unexpected error: %v", test.key, pgno, err)
+			}
+			if !bytes.Equal(p, page) {
+				t.Fatalf("%q Encode(%d) modified the original page", test.key, pgno)
+			}
+			enc = append([]byte(nil), enc...)
+
+			// Cross-check the encoded page
+			ad := []byte{byte(pgno >> 24), byte(pgno >> 16), byte(pgno >> 8), byte(pgno)}
+			ct := append([]byte(nil), enc[:n+16]...)
+			if pgno == 1 {
+				if !bytes.Equal(enc[16:24], page[16:24]) {
+					t.Fatalf("%q Encode(1) altered bytes 16 through 23", test.key)
+				}
+				ad = append(ad, page[16:24]...)
+				ct = append(ct[:16], ct[24:]...)
+			}
+			pt, err2 := ref.Open(nil, c.pNonce(enc), ct, ad)
+			if err2 != nil {
+				t.Fatalf("%q Open(%d) unexpected error: %v", test.key, pgno, err2)
+			}
+			want := page[:n]
+			if pgno == 1 {
+				want = append(append([]byte(nil), page[:16]...), page[24:n]...)
+			}
+			if !bytes.Equal(pt, want) {
+				t.Fatalf("%q Open(%d) plaintext mismatch", test.key, pgno)
+			}
+
+			// Round trip
+			p = append([]byte(nil), enc...)
+			if err := c.Decode(p, pgno, 3); err != nil {
+				t.Fatalf("%q Decode(%d) unexpected error: %v", test.key, pgno, err)
+			}
+			if !bytes.Equal(p[:n], page[:n]) {
+				t.Fatalf("%q Decode(%d) plaintext mismatch", test.key, pgno)
+			}
+
+			// Page number binding
+			p = append([]byte(nil), enc...)
+			if err := c.Decode(p, pgno+2, 3); err == nil {
+				t.Fatalf("%q Decode(%d) expected an error for page %d", test.key, pgno, pgno+2)
+			}
+
+			// Tampering
+			for _, off := range []int{0, 20, n - 1, n + 1} {
+				p = append([]byte(nil), enc...)
+				p[off] ^= 1
+				if err := c.Decode(p, pgno, 3); err == nil {
+					t.Fatalf("%q Decode(%d) expected an error for offset %d", test.key, pgno, off)
+				}
+			}
+		}
+		c.Free()
+	}
+	for _, key := range []string{"aead:chacha20,192:k", "aead:256,chacha20:k", "aead:512:k"} {
+		if _, err := newAEAD(&CodecCtx{}, []byte(key)); err == nil {
+			t.Fatalf("newAEAD(%q) expected an error", key)
+		}
+	}
+}
+
+func newGCM(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+func TestCompress(t *testing.T) {
+	const pageSize = 1024
+	text := bytes.Repeat([]byte("hello, world "), pageSize/13+1)[:pageSize]
+	rand := make([]byte, pageSize)
+	rnd(rand)
+
+	for _, key := range []string{"compress::", "compress:level=9:", "compress:level=0:"} {
+		ci, err := newCompress(&CodecCtx{}, []byte(key))
+		if err != nil {
+			t.Fatalf("newCompress(%q) unexpected error: %v", key, err)
+		}
+		c := ci.(*compress)
+		c.Resize(pageSize, c.Reserve())
+		n := pageSize - c.Reserve()
+
+		tests := []struct {
+			page []byte
+			pgno uint32
+			comp bool
+		}{
+			{text, 1, false},
+			{text, 2, key != "compress:level=0:"},
+			{rand, 3, false},
+		}
+		for _, test := range tests {
+			p := append([]byte(nil), test.page...)
+			enc, err := c.Encode(p, test.pgno, 6)
+			if err != nil {
+				t.Fatalf("%q Encode(%d) unexpected error: %v", key, test.pgno, err)
+			}
+			if !bytes.Equal(p, test.page) {
+				t.Fatalf("%q Encode(%d) modified the original page", key, test.pgno)
+			}
+			size := int(enc[n])<<24 | int(enc[n+1])<<16 | int(enc[n+2])<<8 | int(enc[n+3])
+			if comp := size > 0; comp != test.comp {
+				t.Fatalf("%q Encode(%d) expected compression %t; got size %d", key, test.pgno, test.comp, size)
+			}
+			if size > 0 && !bytes.Equal(enc[size:n], make([]byte, n-size)) {
+				t.Fatalf("%q Encode(%d) page not zero-filled after compressed data", key, test.pgno)
+			}
+			p = append([]byte(nil), enc...)
+			if err := c.Decode(p, test.pgno, 3); err != nil {
+				t.Fatalf("%q Decode(%d) unexpected error: %v", key, test.pgno, err)
+			}
+			if !bytes.Equal(p[:n], test.page[:n]) {
+				t.Fatalf("%q Decode(%d) page mismatch", key, test.pgno)
+			}
+		}
+
+		// Corrupt compressed data
+		enc, _ := c.Encode(append([]byte(nil), text...), 2, 6)
+		if enc[n+3] != 0 {
+			p := append([]byte(nil), enc...)
+			p[n+3]--
+			if err := c.Decode(p, 2, 3); err == nil {
+				t.Fatalf("%q Decode() expected an error for truncated data", key)
+			}
+		}
+		c.Free()
+	}
+	if _, err := newCompress(&CodecCtx{}, []byte("compress:level=10:")); err == nil {
+		t.Fatalf("newCompress() expected an error for level=10")
+	}
+}
@@ -0,0 +1,138 @@
+// Copyright 2013 The Go-SQLite Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package codec
+
+import (
+	"bytes"
+	"compress/flate"
+	"encoding/binary"
+	"io"
+	"strconv"
+
+	. "github.com/mxk/go-sqlite/sqlite3"
+)
+
+// compressHdrLen is the number of bytes reserved in each page for the length of
+// the compressed data.
+const compressHdrLen = 4
+
+type compress struct {
+	key []byte // Key provided to newCompress
+	buf []byte // Page encoding buffer
+
+	// Compressor and decompressor state, reused for all pages
+	zw  *flate.Writer
+	zr  io.ReadCloser
+	src bytes.Reader
+}
+
+func newCompress(ctx *CodecCtx, key []byte) (Codec, *Error) {
+	_, opts, _ := parseKey(key)
+	level := flate.DefaultCompression
+	for k, v := range opts {
+		switch k {
+		case "level":
+			n, err := strconv.Atoi(v)
+			if err != nil {
+				return nil, NewError(MISUSE, "invalid codec option: "+k)
+			}
+			level = n
+		default:
+			return nil, NewError(MISUSE, "invalid codec option: "+k)
+		}
+	}
+	zw, err := flate.NewWriter(nil, level)
+	if err != nil {
+		return nil, NewError(MISUSE, err.Error())
+	}
+	return &compress{key: key, zw: zw, zr: flate.NewReader(nil)}, nil
+}
+
+func (c *compress) Reserve() int {
+	return compressHdrLen
+}
+
+func (c *compress) Resize(pageSize, reserve int) {
+	if reserve != c.Reserve() {
+		panic("sqlite3: codec reserve value mismatch")
+	}
+	c.buf = make([]byte, pageSize)
+}
+
+func (c *compress) Encode(p []byte, n uint32, op int) ([]byte, *Error) {
+	text := c.pText(p)
+	size := 0
+	if n != 1 {
+		w := pageWriter{c.buf[:0:len(text)]}
+		c.zw.Reset(&w)
+		if _, err := c.zw.Write(text); err == nil && c.zw.Close() == nil {
+			size = len(w.b)
+		}
+	}
+	if size == 0 {
+		copy(c.buf, text)
+	} else {
+		wipe(c.buf[size:len(text)])
+	}
+	binary.BigEndian.PutUint32(c.pHdr(c.buf), uint32(size))
+	return c.buf, nil
+}
+
+func (c *compress) Decode(p []byte, n uint32, op int) *Error {
+	size := binary.BigEndian.Uint32(c.pHdr(p))
+	if size == 0 {
+		return nil // Stored without compression
+	}
+	text := c.pText(p)
+	if n == 1 || size > uint32(len(text)) {
+		return codecErr
+	}
+	c.src.Reset(text[:size])
+	if err := c.zr.(flate.Resetter).Reset(&c.src, nil); err != nil {
+		return codecErr
+	}
+	if _, err := io.ReadFull(c.zr, c.buf[:len(text)]); err != nil {
+		return codecErr
+	}
+	if m, err := c.zr.Read(c.buf[len(text) : len(text)+1]); m != 0 || err != io.EOF {
+		return codecErr // Trailing data or an incomplete stream
+	}
+	copy(text, c.buf)
+	return nil
+}
+
+func (c *compress) Key() []byte {
+	return c.key
+}
+
+func (c *compress) Free() {
+	c.buf = nil
+	c.zw = nil
+	c.zr = nil
+}
+
+// pText returns the page subslice that gets compressed.
+func (c *compress) pText(p []byte) []byte {
+	return p[:len(p)-compressHdrLen]
+}
+
+// pHdr returns the page subslice that stores the compressed data length.
+func (c *compress) pHdr(p []byte) []byte {
+	return p[len(p)-compressHdrLen:]
+}
+
+// pageWriter is an io.Writer that fails instead of growing past the capacity of
+// its buffer.
+type pageWriter struct {
+	b []byte
+}
+
+func (w *pageWriter) Write(p []byte) (int, error) {
+	if len(w.b)+len(p) > cap(w.b) {
+		return 0, io.ErrShortWrite
+	}
+	w.b = append(w.b, p...)
+	return len(p), nil
+}
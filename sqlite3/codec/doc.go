@@ -14,6 +14,12 @@ or the KEY clause in an ATTACH statement, to use one of the codecs:
 	c.Key("main", []byte("aes-hmac::secretkey1"))
 	c.Exec("ATTACH DATABASE 'file2.db' AS two KEY 'aes-hmac::secretkey2'")
 
+Codecs may be chained by joining their names with '+'. For example, the key
+"compress+aes-hmac::secretkey1" compresses each page before encrypting it. The
+last codec in the chain receives the master key and all options that are not
+qualified with a codec name. Options for the other codecs are qualified with
+their names, as in "compress+aes-hmac:compress.level=9,256:secretkey1".
+
 If the KEY clause is omitted, SQLite uses the key from the main database, which
 may no longer be valid depending on how the codec is implemented (e.g. aes-hmac
 destroys the master key after initialization). Specify an empty string as the
@@ -87,6 +93,62 @@ change the defaults:
 For example, "aes-hmac:256,ofb,sha256:<master-key>" will use the AES-256-OFB
 cipher and HMAC-SHA256-128 authentication.
 
+AEAD
+
+The aead codec provides authenticated encryption using a single Authenticated
+Encryption with Associated Data (AEAD) cipher, either AES in Galois/Counter Mode
+(GCM) or ChaCha20-Poly1305. Each page has an independent, pseudorandom 96-bit
+nonce, which is regenerated every time the page is encrypted, and a 128-bit
+authentication tag. The page number is bound to the ciphertext as additional
+data, so pages cannot be swapped without detection. The codec requires 28 bytes
+per page to store this information.
+
+The key format is "aead:<options>:<master-key>". The same security warning
+about the master key applies as for aes-hmac. The encryption key is derived from
+the master key using HKDF with SHA-256. The salt is the codec name ("aead")
+extended with NULLs to 32 bytes, and info is the codec configuration string
+(e.g. "aes-128-gcm" or "chacha20-poly1305").
+
+Bytes 16 through 23 of page 1 are stored without encryption, as required by
+SQLite, but they are included in the additional data and are therefore
+authenticated.
+
+The default configuration is AES-128-GCM. The following options may be used to
+change the defaults:
+
+	192
+		AES-192 block cipher.
+	256
+		AES-256 block cipher.
+	chacha20
+		ChaCha20-Poly1305 cipher with a 256-bit key. The key size options
+		cannot be combined with this option.
+
+For example, "aead:256:<master-key>" will use the AES-256-GCM cipher.
+
+COMPRESS
+
+The compress codec uses the DEFLATE algorithm to compress each page. If the
+compressed data fits in the usable area of the page, it is stored at the start
+of the page and the remaining bytes are set to zero. Otherwise, the page is
+stored without compression. Page 1 is never compressed. The codec requires 4
+bytes per page to store the compressed data length, which is 0 for pages that
+are stored without compression.
+
+Compression does not reduce the size of the database file, since all pages
+have the same size, but the runs of zeros make the file highly compressible by
+archival tools and file systems. This codec is most useful for databases that
+contain a lot of text. It is normally chained with one of the encryption codecs
+(e.g. "compress+aead::<master-key>"), in which case compression is performed
+before encryption.
+
+The key format is "compress:<options>:", where <options> is a comma-separated
+list of codec options described below:
+
+	level=N
+		DEFLATE compression level between -2 and 9. The default is -1, which
+		selects the default compression level of the compress/flate package.
+
 HEXDUMP
 
 The hexdump codec logs all method calls and dumps the page content for each
@@ -105,6 +167,7 @@ The following options are supported:
 		Do not output a hex dump of each page.
 	reserve=N
 		Reserve N bytes in each page. The default is -1, which means don't
-		change the current reserve value.
+		change the current reserve value. This option is required when the
+		codec is chained (e.g. "hexdump+aes-hmac:hexdump.reserve=0:<key>").
 */
 package codec
@@ -0,0 +1,108 @@
+// Copyright 2013 The Go-SQLite Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package sqlite3
+
+/*
+#include <stdlib.h>
+#include "sqlite3.h"
+
+// Page cache memory provided to SQLite by CONFIG_PAGECACHE.
+static void *page_cache;
+
+// config_pagecache allocates n pages of sz bytes for the page cache. The
+// previous allocation, if any, is no longer in use because SQLite is not
+// initialized. A zero size or count disables the page cache memory.
+static int config_pagecache(int sz, int n) {
+	void *p = 0;
+	int rc;
+	if (sz > 0 && n > 0 && (p = malloc((size_t)sz * n)) == 0) {
+		return SQLITE_NOMEM;
+	}
+	rc = sqlite3_config(SQLITE_CONFIG_PAGECACHE, p, (p ? sz : 0), (p ? n : 0));
+	if (rc == SQLITE_OK) {
+		free(page_cache);
+		page_cache = p;
+	} else {
+		free(p);
+	}
+	return rc;
+}
+
+// cgo doesn't handle variadic functions.
+static int config(int op, sqlite3_int64 a, sqlite3_int64 b) {
+	switch (op) {
+	case SQLITE_CONFIG_SINGLETHREAD:
+	case SQLITE_CONFIG_MULTITHREAD:
+	case SQLITE_CONFIG_SERIALIZED:
+		return sqlite3_config(op);
+	case SQLITE_CONFIG_MEMSTATUS:
+	case SQLITE_CONFIG_URI:
+	case SQLITE_CONFIG_COVERING_INDEX_SCAN:
+		return sqlite3_config(op, (int)a);
+	case SQLITE_CONFIG_LOOKASIDE:
+		return sqlite3_config(op, (int)a, (int)b);
+	case SQLITE_CONFIG_PAGECACHE:
+		return config_pagecache((int)a, (int)b);
+	case SQLITE_CONFIG_MMAP_SIZE:
+		return sqlite3_config(op, a, b);
+	}
+	return SQLITE_MISUSE;
+}
+*/
+import "C"
+
+// configArgs is the number of arguments expected by each Config option.
+var configArgs = map[int]int{
+	CONFIG_SINGLETHREAD:        0,
+	CONFIG_MULTITHREAD:         0,
+	CONFIG_SERIALIZED:          0,
+	CONFIG_PAGECACHE:           2,
+	CONFIG_MEMSTATUS:           1,
+	CONFIG_LOOKASIDE:           2,
+	CONFIG_URI:                 1,
+	CONFIG_COVERING_INDEX_SCAN: 1,
+	CONFIG_MMAP_SIZE:           2,
+}
+
+// Config changes a global configuration option, specified by one of the CONFIG
+// constants, which determines the number and meaning of args. Boolean options
+// are enabled by any non-zero value. For example, the following call makes
+// memory-mapped I/O available to all connections (see PRAGMA mmap_size):
+//
+// 	sqlite3.Config(sqlite3.CONFIG_MMAP_SIZE, 64<<20, 1<<30)
+//
+// Options may only be changed before SQLite is initialized, which means that
+// Config must be called before the first connection is opened, or after
+// Shutdown. A MISUSE error is returned otherwise.
+//
+// Options that install C callbacks (CONFIG_MALLOC, CONFIG_MUTEX, and
+// CONFIG_PCACHE2) are not supported. In particular, a custom page cache
+// implementation cannot be provided; CONFIG_PAGECACHE only gives the default
+// page cache a preallocated memory buffer.
+// [http://www.sqlite.org/c3ref/config.html]
+func Config(op int, args ...int64) error {
+	n, ok := configArgs[op]
+	if !ok {
+		return pkgErr(MISUSE, "invalid config op (%d)", op)
+	} else if len(args) != n {
+		return pkgErr(MISUSE, "config op %d requires %d argument(s)", op, n)
+	}
+	var a, b int64
+	if n > 0 {
+		a = args[0]
+	}
+	if n > 1 {
+		b = args[1]
+	}
+	initMu.Lock()
+	defer initMu.Unlock()
+	if initDone {
+		return pkgErr(MISUSE, "SQLite is already initialized")
+	}
+	if rc := C.config(C.int(op), C.sqlite3_int64(a), C.sqlite3_int64(b)); rc != OK {
+		return libErr(rc, nil)
+	}
+	return nil
+}
@@ -211,3 +211,18 @@ const (
 	LIMIT_VARIABLE_NUMBER     = C.SQLITE_LIMIT_VARIABLE_NUMBER     // 9
 	LIMIT_TRIGGER_DEPTH       = C.SQLITE_LIMIT_TRIGGER_DEPTH       // 10
 )
+
+// Global configuration options that can be changed with Config before SQLite
+// is initialized. The arguments expected by each option are listed in brackets.
+// [http://www.sqlite.org/c3ref/c_config_getmalloc.html]
+const (
+	CONFIG_SINGLETHREAD        = C.SQLITE_CONFIG_SINGLETHREAD        // 1  []
+	CONFIG_MULTITHREAD         = C.SQLITE_CONFIG_MULTITHREAD         // 2  []
+	CONFIG_SERIALIZED          = C.SQLITE_CONFIG_SERIALIZED          // 3  []
+	CONFIG_PAGECACHE           = C.SQLITE_CONFIG_PAGECACHE           // 7  [size, n]
+	CONFIG_MEMSTATUS           = C.SQLITE_CONFIG_MEMSTATUS           // 9  [bool]
+	CONFIG_LOOKASIDE           = C.SQLITE_CONFIG_LOOKASIDE           // 13 [size, n]
+	CONFIG_URI                 = C.SQLITE_CONFIG_URI                 // 17 [bool]
+	CONFIG_COVERING_INDEX_SCAN = C.SQLITE_CONFIG_COVERING_INDEX_SCAN // 20 [bool]
+	CONFIG_MMAP_SIZE           = C.SQLITE_CONFIG_MMAP_SIZE           // 22 [default, max]
+)
@@ -0,0 +1,120 @@
+// Copyright 2013 The Go-SQLite Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package sqlite3
+
+import (
+	"io"
+	"strings"
+)
+
+// Constraint describes the constraint that caused a CONSTRAINT error. The
+// details are parsed from the error message, so they are only available for
+// the UNIQUE, PRIMARY KEY, NOT NULL, and CHECK constraints, which SQLite
+// reports as "<kind> constraint failed: <details>".
+type Constraint struct {
+	Code    int      // Extended result code (CONSTRAINT_UNIQUE, etc.)
+	Table   string   // Table name (UNIQUE, PRIMARY KEY, and NOT NULL)
+	Columns []string // Column names (UNIQUE, PRIMARY KEY, and NOT NULL)
+
+	// Name is the name of a CHECK constraint or, if the constraint is unnamed,
+	// either the table name or the expression text, depending on the SQLite
+	// version. It is also the index name for UNIQUE indices on expressions.
+	Name string
+}
+
+// Constraint returns the details of a constraint violation, or nil if err is
+// not a CONSTRAINT error or its message does not identify the constraint (e.g.
+// for foreign key violations; see Conn.ForeignKeyCheck).
+func (err *Error) Constraint() *Constraint {
+	if err.rc&0xff != CONSTRAINT {
+		return nil
+	}
+	i := strings.Index(err.msg, " constraint failed: ")
+	if i < 0 {
+		return nil
+	}
+	kind, detail := err.msg[:i], err.msg[i+len(" constraint failed: "):]
+	c := &Constraint{Code: err.rc}
+	switch kind {
+	case "CHECK":
+		c.Name = detail
+	case "UNIQUE", "NOT NULL":
+		if strings.HasPrefix(detail, "index '") && strings.HasSuffix(detail, "'") {
+			c.Name = detail[len("index '") : len(detail)-1]
+			break
+		}
+		for _, col := range strings.Split(detail, ", ") {
+			j := strings.IndexByte(col, '.')
+			if j < 0 {
+				return nil
+			}
+			c.Table = col[:j]
+			c.Columns = append(c.Columns, col[j+1:])
+		}
+	default:
+		return nil
+	}
+	return c
+}
+
+// ForeignKeyViolation is a row that violates a foreign key constraint.
+type ForeignKeyViolation struct {
+	Table  string      // Child table
+	RowID  int64       // Child row ID (0 for WITHOUT ROWID tables)
+	Parent string      // Parent table
+	Key    *ForeignKey // Violated constraint
+}
+
+// ForeignKeyCheck returns all rows in table tbl of database db that violate
+// foreign key constraints. All tables are checked if tbl is "". The check does
+// not depend on whether foreign key enforcement is enabled.
+// [http://www.sqlite.org/pragma.html#pragma_foreign_key_check]
+func (c *Conn) ForeignKeyCheck(db, tbl string) ([]*ForeignKeyViolation, error) {
+	if c.db == nil {
+		return nil, ErrBadConn
+	}
+	qdb := quoteIdent(dbName(db))
+	sql := "PRAGMA " + qdb + ".foreign_key_check"
+	if tbl != "" {
+		sql += "(" + quoteIdent(tbl) + ")"
+	}
+	s, err := c.Query(sql)
+	var vs []*ForeignKeyViolation
+	var fkid []int
+	for ; err == nil; err = s.Next() {
+		v := new(ForeignKeyViolation)
+		var id int
+		if err = s.Scan(&v.Table, &v.RowID, &v.Parent, &id); err != nil {
+			break
+		}
+		vs = append(vs, v)
+		fkid = append(fkid, id)
+	}
+	if s != nil {
+		s.Close()
+	}
+	if err != io.EOF {
+		return nil, err
+	}
+
+	// Resolve constraint IDs
+	fks := make(map[string][]*ForeignKey)
+	for i, v := range vs {
+		keys, ok := fks[v.Table]
+		if !ok {
+			if keys, err = c.foreignKeys(qdb, v.Table); err != nil {
+				return nil, err
+			}
+			fks[v.Table] = keys
+		}
+		for _, fk := range keys {
+			if fk.id == fkid[i] {
+				v.Key = fk
+				break
+			}
+		}
+	}
+	return vs, nil
+}
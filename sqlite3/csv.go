@@ -0,0 +1,271 @@
+// Copyright 2013 The Go-SQLite Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package sqlite3
+
+import (
+	"encoding/csv"
+	"io"
+	"strconv"
+	"strings"
+)
+
+// csvSampleRows is the number of records used to determine column types when
+// Conn.ImportCSV creates a new table.
+const csvSampleRows = 100
+
+// CSVOptions controls the behavior of Conn.ImportCSV. The zero value reads
+// comma-separated records with a header.
+type CSVOptions struct {
+	Comma rune // Field delimiter (default ',')
+
+	// NoHeader indicates that the first record contains data instead of
+	// column names.
+	NoHeader bool
+
+	// Columns, if not empty, specifies the table column for each field,
+	// overriding the header. Without a header or Columns, fields are mapped to
+	// the existing table columns in order, or to new columns named c1, c2, etc.
+	Columns []string
+
+	// EmptyNull causes empty fields to be inserted as NULL instead of empty
+	// strings.
+	EmptyNull bool
+}
+
+// ImportCSV inserts CSV records read from r into table tbl of the main
+// database. If the table does not exist, it is created with one column per
+// field. The declared type of each new column is INTEGER, REAL, or TEXT,
+// depending on the non-empty values in the first 100 records. Field values are
+// always bound as text and converted according to the column affinity. All
+// records are inserted by a single prepared statement within a savepoint, so
+// either the whole file is imported or, if an error is encountered, none of it
+// is.
+func (c *Conn) ImportCSV(tbl string, r io.Reader, opts *CSVOptions) error {
+	if c.db == nil {
+		return ErrBadConn
+	}
+	if opts == nil {
+		opts = new(CSVOptions)
+	}
+	cr := csv.NewReader(r)
+	cr.FieldsPerRecord = -1
+	if opts.Comma != 0 {
+		cr.Comma = opts.Comma
+	}
+	cols := opts.Columns
+	if !opts.NoHeader {
+		hdr, err := cr.Read()
+		if err != nil {
+			if err == io.EOF {
+				err = nil
+			}
+			return err
+		}
+		if len(cols) == 0 {
+			cols = hdr
+		}
+	}
+	cols = append([]string(nil), cols...)
+	if err := c.Exec("SAVEPOINT import_csv"); err != nil {
+		return err
+	}
+	err := c.importCSV(tbl, cr, cols, opts.EmptyNull)
+	if err != nil {
+		c.Exec("ROLLBACK TO import_csv")
+	}
+	c.Exec("RELEASE import_csv")
+	return err
+}
+
+// importCSV creates table tbl, if needed, and inserts all records read from cr.
+func (c *Conn) importCSV(tbl string, cr *csv.Reader, cols []string, emptyNull bool) error {
+	have, err := c.tableColumns(tbl)
+	if err != nil {
+		return err
+	}
+
+	// Buffer some records to determine the number of fields and column types
+	var recs [][]string
+	for len(recs) < csvSampleRows {
+		rec, err := cr.Read()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return err
+		}
+		recs = append(recs, rec)
+	}
+	n := len(cols)
+	if n == 0 {
+		if len(recs) == 0 {
+			return nil
+		}
+		if n = len(recs[0]); len(have) > 0 {
+			if n > len(have) {
+				return pkgErr(ERROR, "table %q has %d columns but %d values "+
+					"were supplied", tbl, len(have), n)
+			}
+			cols = have[:n]
+		} else {
+			cols = make([]string, n)
+		}
+	}
+	for i := range cols {
+		if cols[i] == "" {
+			cols[i] = "c" + strconv.Itoa(i+1)
+		}
+	}
+	if len(have) == 0 {
+		if err = c.Exec(csvCreate(tbl, cols, recs)); err != nil {
+			return err
+		}
+	}
+
+	// Insert all records
+	sql := make([]string, n)
+	for i, col := range cols {
+		sql[i] = quoteIdent(col)
+	}
+	s, err := c.Prepare("INSERT INTO " + quoteIdent(tbl) + "(" +
+		strings.Join(sql, ",") + ") VALUES(" +
+		strings.TrimSuffix(strings.Repeat("?,", n), ",") + ")")
+	if err != nil {
+		return err
+	}
+	defer s.Close()
+	args := make([]interface{}, n)
+	nrec := 0
+	insert := func(rec []string) error {
+		if nrec++; len(rec) != n {
+			return pkgErr(ERROR, "record %d has %d fields; expected %d", nrec,
+				len(rec), n)
+		}
+		for i, v := range rec {
+			if v == "" && emptyNull {
+				args[i] = nil
+			} else {
+				args[i] = RawString(v)
+			}
+		}
+		return s.Exec(args...)
+	}
+	for _, rec := range recs {
+		if err = insert(rec); err != nil {
+			return err
+		}
+	}
+	for {
+		rec, err := cr.Read()
+		if err == io.EOF {
+			return nil
+		} else if err != nil {
+			return err
+		}
+		if err = insert(rec); err != nil {
+			return err
+		}
+	}
+}
+
+// tableColumns returns the names of all columns in table tbl of the main
+// database, or nil if the table does not exist.
+func (c *Conn) tableColumns(tbl string) ([]string, error) {
+	s, err := c.Query("PRAGMA main.table_info(" + quoteIdent(tbl) + ")")
+	var cols []string
+	var name string
+	for ; err == nil; err = s.Next() {
+		if err = s.Scan(nil, &name); err != nil {
+			break
+		}
+		cols = append(cols, name)
+	}
+	if s != nil {
+		s.Close()
+	}
+	if err != io.EOF {
+		return nil, err
+	}
+	return cols, nil
+}
+
+// csvCreate returns a CREATE TABLE statement for the specified columns. Column
+// types are determined from the values in recs.
+func csvCreate(tbl string, cols []string, recs [][]string) string {
+	sql := "CREATE TABLE " + quoteIdent(tbl) + "("
+	for i, col := range cols {
+		if i > 0 {
+			sql += ", "
+		}
+		sql += quoteIdent(col) + " " + csvType(recs, i)
+	}
+	return sql + ")"
+}
+
+// csvType returns the declared type for field i.
+func csvType(recs [][]string, i int) string {
+	typ := ""
+	for _, rec := range recs {
+		if i >= len(rec) || rec[i] == "" {
+			continue
+		}
+		v := strings.TrimSpace(rec[i])
+		if _, err := strconv.ParseInt(v, 10, 64); err == nil {
+			if typ == "" {
+				typ = "INTEGER"
+			}
+		} else if _, err := strconv.ParseFloat(v, 64); err == nil {
+			typ = "REAL"
+		} else {
+			return "TEXT"
+		}
+	}
+	if typ == "" {
+		typ = "TEXT"
+	}
+	return typ
+}
+
+// WriteCSV writes the column names and all remaining rows returned by s to w as
+// CSV records. NULL values are written as empty fields and BLOB values are
+// written without any encoding. Column values are scanned as RawString, so no
+// copies are made before they are written. The statement is executed first if
+// it is not already busy.
+func (s *Stmt) WriteCSV(w io.Writer) error {
+	if s.stmt == nil {
+		return ErrBadStmt
+	}
+	var err error
+	if !s.Busy() {
+		if err = s.Query(); err != nil && err != io.EOF {
+			return err
+		}
+	}
+	cw := csv.NewWriter(w)
+	if err2 := cw.Write(s.Columns()); err2 != nil {
+		return err2
+	}
+	raw := make([]RawString, s.nCols)
+	dst := make([]interface{}, s.nCols)
+	rec := make([]string, s.nCols)
+	for i := range raw {
+		dst[i] = &raw[i]
+	}
+	for ; err == nil; err = s.Next() {
+		if err = s.Scan(dst...); err != nil {
+			return err
+		}
+		for i, v := range raw {
+			rec[i] = string(v)
+		}
+		if err = cw.Write(rec); err != nil {
+			return err
+		}
+	}
+	if err != io.EOF {
+		return err
+	}
+	cw.Flush()
+	return cw.Error()
+}
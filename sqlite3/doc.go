@@ -9,15 +9,40 @@ Database connections are created either by using this package directly or with
 the "sqlite3" database/sql driver. The direct interface, which is described
 below, exposes SQLite-specific features, such as incremental I/O and online
 backups. The driver is recommended when your application has to support multiple
-database engines.
+database engines. Connections created either way can be initialized by functions
+registered with RegisterConnectHook, and RegisterDriver adds drivers with their
+own initialization functions under other names.
+
+The SQLite library is initialized when the first connection is opened. Global
+options, such as the lookaside and mmap sizes, may be changed with Config before
+that happens (or after Shutdown). Call Initialize to perform and check the
+initialization explicitly. SQLite allocates memory outside of the Go heap, so
+it is not included in Go runtime statistics. ReadStats reports SQLite memory use
+and other global counters, and SoftHeapLimit controls how much memory SQLite
+should allocate. HardHeapLimit enforces a strict limit, but it requires SQLite
+3.31.0 or later and returns an error without the libsqlite3 tag.
 
 Installation
 
 Minimum requirements are Go 1.1+ with CGO enabled and GCC/MinGW C compiler. The
 SQLite amalgamation version 3.8.5 (2014-06-04) is compiled as part of the
 package (see http://www.sqlite.org/amalgamation.html). Compilation options are
-defined at the top of sqlite3.go (#cgo CFLAGS). Dynamic linking with a shared
-SQLite library is not supported.
+defined at the top of sqlite3.go (#cgo CFLAGS).
+
+The libsqlite3 build tag links the package with the system SQLite library, which
+is located by pkg-config, instead of compiling the bundled amalgamation. This
+reduces build time and allows SQLite to be updated independently, but codec
+support is disabled in this mode: Conn.Key and Conn.Rekey return ErrNoCodec, and
+the codec package has no effect. Features added after SQLite 3.8.5, such as
+FTS5, JSON1, window functions, and UPSERT, are only available in this mode, if
+the system library provides them.
+
+Loadable extensions (Conn.LoadExtension) are disabled in the bundled
+amalgamation unless the package is built with the sqlite_load_extension tag
+(e.g. 'go build -tags sqlite_load_extension'). They are always available with
+the libsqlite3 tag, provided that the system library supports them. Statically
+linked extensions can be registered with AutoExtension in all configurations,
+but without either tag only those compiled with SQLITE_CORE are supported.
 
 Windows users should install mingw-w64 (http://mingw-w64.sourceforge.net/),
 TDM64-GCC (http://tdm-gcc.tdragon.net/), or another MinGW distribution, and make
@@ -90,6 +115,8 @@ statements and may be used in NamedArgs:
 	                        for the duration of the query.
 	ZeroBlob   BLOB         Allocates a zero-filled BLOB of the specified length
 	                        (e.g. ZeroBlob(4096) allocates 4KB).
+	JSON       TEXT         JSON{v} is encoded by calling json.Marshal(v). Other
+	                        json.Marshaler values are encoded the same way.
 
 Note that the table above describes how the value is bound to the statement. The
 final storage class is determined according to the column affinity rules.
@@ -114,6 +141,8 @@ types are supported for retrieving column values:
 	*RawBytes   BLOB       Same as *RawString. The value must not be modified.
 	                       Re-slicing is ok, but be careful with append().
 	io.Writer   BLOB       The value is written out directly into the writer.
+	JSON        TEXT       JSON{&v} is decoded by calling json.Unmarshal. Other
+	                       json.Unmarshaler values are decoded the same way.
 
 For *interface{} and RowMap arguments, the Go data type is dynamically selected
 based on the SQLite storage class and column declaration prefix:
@@ -145,6 +174,15 @@ anything to modify the associated database connection. This includes
 preparing/running any other SQL statements. The safest bet is to avoid all
 interactions with Conn, Stmt, and other related objects within the handler.
 
+The SQLite error log, which also reports warnings and notices such as automatic
+index creation and journal recovery, is sent to the function installed by
+SetLogger. SlogLogger adapts a log/slog logger for this purpose (Go 1.21+).
+
+Statement execution can be monitored by registering an Instrument with
+Conn.Instrument. It receives the duration, row count, and STMTSTATUS counters
+of each statement run. Collector is an Instrument that aggregates these values
+by normalized SQL text and reports the slowest and full-scan queries.
+
 Codecs and Encryption
 
 SQLite has an undocumented codec API, which operates between the pager and VFS
@@ -159,18 +197,36 @@ and several existing codec implementations.
 Codecs are registered via the RegisterCodec function for a specific key prefix.
 For example, the "aes-hmac" codec is initialized when a key in the format
 "aes-hmac:<...>" is provided to an attached database. The key format after the
-first colon is codec-specific. See CodecFunc for more information.
+first colon is codec-specific. Multiple codecs may be chained by joining their
+names with '+' (e.g. "compress+aes-hmac:<...>"). See CodecFunc and RegisterCodec
+for more information.
 
 The codec API has several limitations. Codecs cannot be used for in-memory or
 temporary databases. Once a database is created, the page size and the amount of
 reserved space at the end of each page cannot be changed (i.e. "PRAGMA
-page_size=N; VACUUM;" will not work). Online backups will fail unless the
-destination database has the same page size and reserve values. Bytes 16 through
-23 of page 1 (the database header, see http://www.sqlite.org/fileformat2.html)
-cannot be altered, so it is always possible to identify encrypted SQLite
-databases.
-
-The rekey function is currently not implemented. The key can only be changed via
-the backup API or by dumping and restoring the database contents.
+page_size=N; VACUUM;" will not work). Online backups require the source and
+destination databases to have the same page size and reserve values. When the
+destination has a codec, Conn.Backup changes its page size to match the source
+(this is only possible if the destination is empty), and returns a READONLY
+error if the reserve values, which are determined by the codecs, are different.
+Plaintext databases normally have no reserved space, so encrypting one with the
+backup API fails with this error. Conn.BackupTo and Conn.BackupToFile handle
+this case by copying the schema and contents into a new destination database
+with SQL statements instead. Bytes 16 through 23 of page 1 (the database
+header, see http://www.sqlite.org/fileformat2.html) cannot be altered, so it is
+always possible to identify encrypted SQLite databases.
+
+SQLite interprets all codec errors as out-of-memory conditions. This package
+converts them back before returning them to the caller, so a database that
+cannot be decoded (e.g. because of an invalid key or a failed authentication
+check) results in a NOTADB or CORRUPT error code. Use Conn.CodecError to get the
+last error reported by the codec of a specific database.
+
+Conn.Key and the KEY clause of an ATTACH statement verify the key by decoding
+page 1 of an existing database. An invalid key is reported immediately with a
+NOTADB error code. Use Conn.KeyCheck to test the current key at any other time.
+
+The rekey function is currently not implemented. The key can only be changed by
+copying the database into a new one with Conn.BackupTo or Conn.BackupToFile.
 */
 package sqlite3
@@ -23,12 +23,15 @@ func register(name string) {
 	sql.Register(name, Driver(name))
 }
 
-func (Driver) Open(name string) (driver.Conn, error) {
-	c, err := Open(name)
+func (d Driver) Open(name string) (driver.Conn, error) {
+	c, err := open(name, "")
 	if err != nil {
 		return nil, err
 	}
 	c.BusyTimeout(5 * time.Second)
+	if err = c.connect(driverHook(string(d))); err != nil {
+		return nil, err
+	}
 	return &conn{c}, nil
 }
 
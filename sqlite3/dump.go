@@ -0,0 +1,424 @@
+// Copyright 2013 The Go-SQLite Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package sqlite3
+
+/*
+#include "sqlite3.h"
+*/
+import "C"
+
+import (
+	"bufio"
+	"encoding/hex"
+	"io"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// DumpOptions controls the output of Conn.Dump. The zero value dumps the
+// schema and contents of all tables.
+type DumpOptions struct {
+	// Tables limits the output to the specified tables and views, and the
+	// indices and triggers associated with them. All objects are dumped if
+	// the slice is empty. The AUTOINCREMENT state is only included if
+	// sqlite_sequence is one of the specified tables.
+	Tables []string
+
+	SchemaOnly bool // Omit INSERT statements
+	DataOnly   bool // Omit CREATE statements
+}
+
+// Dump writes SQL statements that recreate the schema and contents of database
+// db (e.g. "main") to w, similar to the .dump command of the sqlite3 shell. The
+// output contains CREATE TABLE statements, followed by INSERT statements for
+// each table, the contents of sqlite_sequence and sqlite_stat tables, and
+// finally the CREATE statements for indices, triggers, and views. Virtual
+// tables are recreated by inserting their definitions into sqlite_master (with
+// "PRAGMA writable_schema=ON"), and their contents are restored via the shadow
+// tables that store them. Such tables are not visible to the connection that
+// executed the statements until it is reopened, because SQLite does not reload
+// the schema after sqlite_master is modified directly. Schema statements are
+// not qualified with a database name, so the output restores into the main
+// database of another connection. ROWIDs are only preserved for tables with an
+// INTEGER PRIMARY KEY column. Generated columns are omitted from INSERT
+// statements.
+//
+// The database is read within a savepoint, so the output is a consistent
+// snapshot. Use Restore to execute the generated statements.
+func (c *Conn) Dump(w io.Writer, db string, opts *DumpOptions) error {
+	if c.db == nil {
+		return ErrBadConn
+	}
+	if opts == nil {
+		opts = new(DumpOptions)
+	}
+	if err := c.Exec("SAVEPOINT dump"); err != nil {
+		return err
+	}
+	defer c.Exec("RELEASE dump")
+
+	d := &dumper{
+		c:    c,
+		w:    bufio.NewWriter(w),
+		db:   quoteIdent(dbName(db)),
+		opts: opts,
+	}
+	if err := d.dump(); err != nil {
+		return err
+	}
+	return d.w.Flush()
+}
+
+// Restore executes SQL statements read from r, such as the output of Dump,
+// within a single transaction. Statements are executed one at a time as soon as
+// they are complete (see Complete), so the input does not need to fit in
+// memory. Foreign key constraints are checked only when the transaction is
+// committed. The input must not contain transaction control statements (e.g.
+// the BEGIN TRANSACTION and COMMIT statements added by the sqlite3 shell). If
+// any statement fails, the transaction is rolled back and the error is
+// returned.
+func (c *Conn) Restore(r io.Reader) error {
+	if c.db == nil {
+		return ErrBadConn
+	}
+	if err := c.Begin(); err != nil {
+		return err
+	}
+	if err := c.restore(r); err != nil {
+		c.Rollback()
+		return err
+	}
+	return c.Commit()
+}
+
+// restore executes the statements read from r.
+func (c *Conn) restore(r io.Reader) error {
+	if err := c.Exec("PRAGMA defer_foreign_keys=ON"); err != nil {
+		return err
+	}
+	br := bufio.NewReader(r)
+	var sql []byte
+	var sc sqlScanner
+	for {
+		line, err := br.ReadSlice('\n')
+		sql = append(sql, line...)
+		if err == bufio.ErrBufferFull {
+			continue
+		} else if err != nil && err != io.EOF {
+			return err
+		}
+		if len(sql) > 0 && (err == io.EOF || sc.scan(sql) && Complete(string(sql))) {
+			if err := c.Exec(string(sql)); err != nil {
+				return err
+			}
+			sql, sc = sql[:0], sqlScanner{}
+		}
+		if err == io.EOF {
+			return nil
+		}
+	}
+}
+
+// sqlScanner finds the semicolons that may end an SQL statement, ignoring those
+// in string literals, quoted identifiers, and comments. Each byte of the
+// statement is examined once, so Restore only needs to call Complete, which
+// parses the entire statement, when the statement may be complete.
+type sqlScanner struct {
+	off  int  // Length of the text that was already scanned
+	term byte // Expected terminator of the current literal or comment, or 0
+}
+
+// scan examines the text in sql that was added since the last call and reports
+// whether it contains a semicolon outside of any literals and comments. sql must
+// be the previous text with new text appended.
+func (s *sqlScanner) scan(sql []byte) (semi bool) {
+	for i := s.off; i < len(sql); i++ {
+		switch c := sql[i]; s.term {
+		case 0:
+			switch c {
+			case ';':
+				semi = true
+			case '\'', '"', '`':
+				s.term = c
+			case '[':
+				s.term = ']'
+			case '-', '/':
+				if i+1 == len(sql) {
+					s.off = i // Wait for the next character
+					return
+				}
+				if next := sql[i+1]; c == '-' && next == '-' {
+					s.term, i = '\n', i+1
+				} else if c == '/' && next == '*' {
+					s.term, i = '/', i+1
+				}
+			}
+		case '/':
+			if c == '*' {
+				if i+1 == len(sql) {
+					s.off = i
+					return
+				}
+				if sql[i+1] == '/' {
+					s.term, i = 0, i+1
+				}
+			}
+		default:
+			if c == s.term {
+				s.term = 0
+			}
+		}
+	}
+	s.off = len(sql)
+	return
+}
+
+// dumper generates the output of Conn.Dump.
+type dumper struct {
+	c    *Conn
+	w    *bufio.Writer
+	db   string // Quoted database name
+	opts *DumpOptions
+	buf  []byte // INSERT statement buffer
+
+	writableSchema bool // "PRAGMA writable_schema=ON" was written
+	analyzed       bool // "ANALYZE sqlite_master" was written
+}
+
+// dumpObj is a schema object from sqlite_master.
+type dumpObj struct {
+	typ, name, tbl, sql string
+}
+
+// dump writes all statements to d.w.
+func (d *dumper) dump() error {
+	objs, err := d.schema()
+	if err != nil {
+		return err
+	}
+	for _, o := range objs {
+		if err = d.object(o); err != nil {
+			return err
+		}
+	}
+	if d.writableSchema {
+		d.w.WriteString("PRAGMA writable_schema=OFF;\n")
+	}
+	return nil
+}
+
+// schema returns the objects that should be dumped. Tables are returned first,
+// with sqlite_sequence after all other tables. Other objects follow in the
+// order in which they were created.
+func (d *dumper) schema() ([]*dumpObj, error) {
+	var tables map[string]bool
+	if len(d.opts.Tables) > 0 {
+		tables = make(map[string]bool, len(d.opts.Tables))
+		for _, name := range d.opts.Tables {
+			tables[strings.ToLower(name)] = true
+		}
+	}
+	s, err := d.c.Query("SELECT type, name, tbl_name, sql FROM " + d.db +
+		".sqlite_master WHERE sql NOT NULL " +
+		"ORDER BY type!='table', name='sqlite_sequence', rowid")
+	var objs []*dumpObj
+	for ; err == nil; err = s.Next() {
+		o := new(dumpObj)
+		if err = s.Scan(&o.typ, &o.name, &o.tbl, &o.sql); err != nil {
+			break
+		}
+		if tables == nil || tables[strings.ToLower(o.tbl)] {
+			objs = append(objs, o)
+		}
+	}
+	if s != nil {
+		s.Close()
+	}
+	if err != io.EOF {
+		return nil, err
+	}
+	return objs, nil
+}
+
+// object writes the statements for one schema object.
+func (d *dumper) object(o *dumpObj) error {
+	schema, data := !d.opts.DataOnly, !d.opts.SchemaOnly
+	if o.typ != "table" {
+		if schema {
+			d.w.WriteString(o.sql + ";\n")
+		}
+		return nil
+	}
+	switch lname := strings.ToLower(o.name); {
+	case lname == "sqlite_sequence":
+		if data {
+			d.w.WriteString("DELETE FROM sqlite_sequence;\n")
+			return d.rows(o.name)
+		}
+	case strings.HasPrefix(lname, "sqlite_stat"):
+		if data {
+			if !d.analyzed {
+				// Creates sqlite_stat tables in the destination database
+				d.w.WriteString("ANALYZE sqlite_master;\n")
+				d.analyzed = true
+			}
+			return d.rows(o.name)
+		}
+	case strings.HasPrefix(lname, "sqlite_"):
+		// Internal table created automatically
+	case strings.HasPrefix(strings.ToUpper(o.sql), "CREATE VIRTUAL TABLE"):
+		if schema {
+			if !d.writableSchema {
+				d.w.WriteString("PRAGMA writable_schema=ON;\n")
+				d.writableSchema = true
+			}
+			d.w.WriteString("INSERT INTO sqlite_master(type,name,tbl_name," +
+				"rootpage,sql) VALUES('table',")
+			d.buf = appendText(d.buf[:0], o.name)
+			d.buf = append(d.buf, ',')
+			d.buf = appendText(d.buf, o.tbl)
+			d.buf = append(d.buf, ",0,"...)
+			d.buf = appendText(d.buf, o.sql)
+			d.w.Write(d.buf)
+			d.w.WriteString(");\n")
+		}
+	default:
+		if schema {
+			d.w.WriteString(o.sql + ";\n")
+		}
+		if data {
+			return d.rows(o.name)
+		}
+	}
+	return nil
+}
+
+// rows writes an INSERT statement for each row in table tbl.
+func (d *dumper) rows(tbl string) error {
+	cols, err := d.columns(tbl)
+	if err != nil {
+		return err
+	}
+	prefix := "INSERT INTO " + quoteIdent(tbl) + " VALUES("
+	sel := "*"
+	if cols != "" {
+		prefix = "INSERT INTO " + quoteIdent(tbl) + "(" + cols + ") VALUES("
+		sel = cols
+	}
+	s, err := d.c.Query("SELECT " + sel + " FROM " + d.db + "." + quoteIdent(tbl))
+	if err != nil {
+		if err == io.EOF {
+			err = nil
+		}
+		return err
+	}
+	defer s.Close()
+	for n := C.int(s.nCols); err == nil; err = s.Next() {
+		d.buf = append(d.buf[:0], prefix...)
+		for i := C.int(0); i < n; i++ {
+			if i > 0 {
+				d.buf = append(d.buf, ',')
+			}
+			d.buf = s.appendLiteral(d.buf, i)
+		}
+		d.buf = append(d.buf, ");\n"...)
+		if _, err = d.w.Write(d.buf); err != nil {
+			return err
+		}
+	}
+	if err == io.EOF {
+		err = nil
+	}
+	return err
+}
+
+// columns returns a list of the quoted names of the columns in table tbl that
+// can be inserted, or "" if there are no hidden or generated columns. PRAGMA
+// table_xinfo, which reports such columns, is not available before SQLite
+// 3.26.0, in which case all columns are included.
+func (d *dumper) columns(tbl string) (string, error) {
+	s, err := d.c.Query("PRAGMA " + d.db + ".table_xinfo(" + quoteIdent(tbl) + ")")
+	var cols []string
+	var name string
+	var hidden int
+	skip := false
+	for ; err == nil; err = s.Next() {
+		if err = s.Scan(nil, &name, nil, nil, nil, nil, &hidden); err != nil {
+			break
+		}
+		if hidden != 0 {
+			skip = true
+		} else {
+			cols = append(cols, quoteIdent(name))
+		}
+	}
+	if s != nil {
+		s.Close()
+	}
+	if err != io.EOF {
+		return "", err
+	}
+	if !skip {
+		return "", nil
+	}
+	return strings.Join(cols, ","), nil
+}
+
+// appendLiteral appends the value of column i as an SQL literal to b.
+func (s *Stmt) appendLiteral(b []byte, i C.int) []byte {
+	switch s.colType(i) {
+	case INTEGER:
+		return strconv.AppendInt(b, int64(C.sqlite3_column_int64(s.stmt, i)), 10)
+	case FLOAT:
+		return appendFloat(b, float64(C.sqlite3_column_double(s.stmt, i)))
+	case TEXT:
+		return appendText(b, text(s.stmt, i, false))
+	case BLOB:
+		v := blob(s.stmt, i, false)
+		b = append(b, "X'"...)
+		n := len(b)
+		b = append(b, make([]byte, hex.EncodedLen(len(v)))...)
+		hex.Encode(b[n:], v)
+		return append(b, '\'')
+	}
+	return append(b, "NULL"...)
+}
+
+// appendFloat appends v to b in a format that SQLite will parse as a REAL value.
+func appendFloat(b []byte, v float64) []byte {
+	switch {
+	case math.IsInf(v, 1):
+		return append(b, "1e999"...)
+	case math.IsInf(v, -1):
+		return append(b, "-1e999"...)
+	case math.IsNaN(v):
+		return append(b, "NULL"...)
+	}
+	n := len(b)
+	b = strconv.AppendFloat(b, v, 'g', -1, 64)
+	for _, c := range b[n:] {
+		if c == '.' || c == 'e' {
+			return b
+		}
+	}
+	return append(b, ".0"...)
+}
+
+// appendText appends v to b as a quoted SQL string literal.
+func appendText(b []byte, v string) []byte {
+	b = append(b, '\'')
+	for {
+		i := strings.IndexByte(v, '\'')
+		if i < 0 {
+			break
+		}
+		b = append(b, v[:i+1]...)
+		b = append(b, '\'')
+		v = v[i+1:]
+	}
+	b = append(b, v...)
+	return append(b, '\'')
+}
@@ -0,0 +1,134 @@
+// Copyright 2013 The Go-SQLite Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package sqlite3
+
+/*
+#include "sqlite3.h"
+
+// Wrappers for sqlite3_load_extension and sqlite3_enable_load_extension that
+// allow extension loading to be disabled.
+static int load_extension(sqlite3 *db, const char *zFile, const char *zProc, char **pzErrMsg) {
+#ifdef SQLITE_OMIT_LOAD_EXTENSION
+	return -1;
+#else
+	return sqlite3_load_extension(db, zFile, zProc, pzErrMsg);
+#endif
+}
+
+static int enable_load_extension(sqlite3 *db, int onoff) {
+#ifdef SQLITE_OMIT_LOAD_EXTENSION
+	return -1;
+#else
+	return sqlite3_enable_load_extension(db, onoff);
+#endif
+}
+
+// cgo doesn't support calls via function pointers.
+static int auto_extension(void *xEntryPoint) {
+	return sqlite3_auto_extension((void(*)(void))xEntryPoint);
+}
+*/
+import "C"
+
+import "unsafe"
+
+// ErrNoLoadExtension is returned by Conn.LoadExtension and
+// Conn.EnableLoadExtension if the package was built without the
+// sqlite_load_extension tag.
+var ErrNoLoadExtension = &Error{rc: ERROR, msg: "extension loading is disabled"}
+
+// LoadExtension loads an SQLite extension from the shared library at path.
+// Entry is the name of the extension initialization function. If entry is "",
+// SQLite derives the name from the file name (e.g. "sqlite3_spatialite_init"
+// for "mod_spatialite.so"), falling back to "sqlite3_extension_init". Extension
+// loading must first be enabled by calling EnableLoadExtension.
+// [http://www.sqlite.org/c3ref/load_extension.html]
+func (c *Conn) LoadExtension(path, entry string) error {
+	if c.db == nil {
+		return ErrBadConn
+	}
+	path += "\x00"
+	var zProc *C.char
+	if entry != "" {
+		entry += "\x00"
+		zProc = cStr(entry)
+	}
+	var zErrMsg *C.char
+	rc := C.load_extension(c.db, cStr(path), zProc, &zErrMsg)
+	if rc != OK {
+		if rc == -1 {
+			return ErrNoLoadExtension
+		}
+		if zErrMsg != nil {
+			defer C.sqlite3_free(unsafe.Pointer(zErrMsg))
+			return &Error{rc: int(rc), msg: C.GoString(zErrMsg)}
+		}
+		return libErr(rc, c.db)
+	}
+	return nil
+}
+
+// EnableLoadExtension enables or disables extension loading for this
+// connection, both via LoadExtension and the SQL load_extension() function. It
+// is disabled by default.
+// [http://www.sqlite.org/c3ref/enable_load_extension.html]
+func (c *Conn) EnableLoadExtension(on bool) error {
+	if c.db == nil {
+		return ErrBadConn
+	}
+	var onoff C.int
+	if on {
+		onoff = 1
+	}
+	if rc := C.enable_load_extension(c.db, onoff); rc != OK {
+		if rc == -1 {
+			return ErrNoLoadExtension
+		}
+		return libErr(rc, c.db)
+	}
+	return nil
+}
+
+// AutoExtension registers a statically linked extension that will be
+// initialized for every new connection. Entry must be a pointer to the C
+// extension initialization function, which has the signature:
+//
+//	int xEntryPoint(sqlite3 *db, char **pzErrMsg, const sqlite3_api_routines *pApi);
+//
+// It is typically obtained in a separate cgo package that links the extension,
+// for example: unsafe.Pointer(C.sqlite3_myext_init). Registering the same
+// function more than once has no effect. Existing connections are not affected.
+//
+// Unless the package is built with the sqlite_load_extension or libsqlite3 tag,
+// SQLite passes a zeroed pApi structure to the entry point. Only extensions
+// compiled with the SQLITE_CORE option, which call the SQLite API directly, work
+// in that configuration. Extensions that use SQLITE_EXTENSION_INIT2 from
+// sqlite3ext.h crash when they call any API function through pApi.
+//
+// Shutdown unregisters all extensions, so they must be registered again after
+// the library is shut down.
+// [http://www.sqlite.org/c3ref/auto_extension.html]
+func AutoExtension(entry unsafe.Pointer) error {
+	if err := Initialize(); err != nil {
+		return err
+	}
+	if entry == nil {
+		return pkgErr(MISUSE, "nil extension entry point")
+	}
+	if rc := C.auto_extension(entry); rc != OK {
+		return libErr(rc, nil)
+	}
+	return nil
+}
+
+// ResetAutoExtension unregisters all extensions registered by AutoExtension.
+// [http://www.sqlite.org/c3ref/reset_auto_extension.html]
+func ResetAutoExtension() error {
+	if err := Initialize(); err != nil {
+		return err
+	}
+	C.sqlite3_reset_auto_extension()
+	return nil
+}
@@ -0,0 +1,311 @@
+// Copyright 2013 The Go-SQLite Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package sqlite3
+
+import (
+	"bufio"
+	"encoding/csv"
+	"encoding/hex"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+// Output modes for Formatter.
+const (
+	FormatColumns  = iota // Aligned columns separated by spaces
+	FormatBox             // Aligned columns surrounded by box-drawing characters
+	FormatMarkdown        // GitHub-flavored Markdown table
+	FormatCSV             // RFC 4180 records (see also Stmt.WriteCSV)
+)
+
+// Formatter renders query results as text. The zero value writes all rows in
+// aligned columns with a header, and displays NULL values as empty strings.
+//
+// In all modes other than FormatCSV, the rows are buffered in memory to
+// determine column widths, newlines and tabs are replaced with spaces, and
+// BLOBs are displayed as hexadecimal literals (X'...'). Columns that contain
+// only numeric values (and NULLs) are aligned to the right.
+type Formatter struct {
+	Mode int // Output mode (FormatColumns, FormatBox, FormatMarkdown, FormatCSV)
+
+	// MaxWidth is the maximum number of characters in each column. Longer
+	// values are truncated and end with an ellipsis. Zero means no limit. It
+	// does not apply to FormatCSV output.
+	MaxWidth int
+
+	// MaxRows is the maximum number of rows to write. Zero means no limit.
+	// Formatter stops reading from the statement after MaxRows rows.
+	MaxRows int
+
+	Null     string // Text displayed for NULL values
+	NoHeader bool   // Omit column names (ignored by FormatMarkdown)
+}
+
+// Format writes the rows returned by s to w. The statement is executed first if
+// it is not already busy. Otherwise, the output begins with the current row.
+func (f *Formatter) Format(w io.Writer, s *Stmt) error {
+	if s == nil || s.stmt == nil {
+		return ErrBadStmt
+	}
+	var err error
+	if !s.Busy() {
+		if err = s.Query(); err != nil && err != io.EOF {
+			return err
+		}
+	}
+	bw := bufio.NewWriter(w)
+	if f.Mode == FormatCSV {
+		err = f.csv(bw, s, err)
+	} else {
+		err = f.table(bw, s, err)
+	}
+	if err != nil {
+		return err
+	}
+	return bw.Flush()
+}
+
+// rows calls fn with the formatted values of each row, until MaxRows rows have
+// been processed. The value of err is the result of the previous Query call.
+func (f *Formatter) rows(s *Stmt, err error, fn func(cells []string, num []bool) error) error {
+	vals := make([]interface{}, s.nCols)
+	dst := make([]interface{}, s.nCols)
+	cells := make([]string, s.nCols)
+	num := make([]bool, s.nCols)
+	for i := range vals {
+		dst[i] = &vals[i]
+	}
+	for n := 0; err == nil && (f.MaxRows <= 0 || n < f.MaxRows); n++ {
+		if err = s.Scan(dst...); err != nil {
+			return err
+		}
+		for i, v := range vals {
+			cells[i], num[i] = f.cell(v)
+		}
+		if err = fn(cells, num); err != nil {
+			return err
+		}
+		if f.MaxRows <= 0 || n+1 < f.MaxRows {
+			err = s.Next()
+		}
+	}
+	if err == io.EOF {
+		err = nil
+	}
+	return err
+}
+
+// cell returns the text representation of v and whether it is a number.
+func (f *Formatter) cell(v interface{}) (string, bool) {
+	switch v := v.(type) {
+	case nil:
+		return f.Null, true
+	case int64:
+		return strconv.FormatInt(v, 10), true
+	case float64:
+		return strconv.FormatFloat(v, 'g', -1, 64), true
+	case bool:
+		return strconv.FormatBool(v), false
+	case time.Time:
+		return v.Format(time.RFC3339), false
+	case string:
+		if f.Mode == FormatCSV {
+			return v, false
+		}
+		return strings.Map(flatten, v), false
+	case []byte:
+		if f.Mode == FormatCSV {
+			return string(v), false
+		}
+		return "X'" + hex.EncodeToString(v) + "'", false
+	}
+	return "", false
+}
+
+// flatten replaces control characters that would break table alignment.
+func flatten(r rune) rune {
+	if r == '\n' || r == '\r' || r == '\t' {
+		return ' '
+	}
+	return r
+}
+
+// csv writes rows as CSV records.
+func (f *Formatter) csv(w io.Writer, s *Stmt, err error) error {
+	cw := csv.NewWriter(w)
+	if !f.NoHeader {
+		if err := cw.Write(s.Columns()); err != nil {
+			return err
+		}
+	}
+	err = f.rows(s, err, func(cells []string, _ []bool) error {
+		return cw.Write(cells)
+	})
+	if err != nil {
+		return err
+	}
+	cw.Flush()
+	return cw.Error()
+}
+
+// table writes rows as aligned columns.
+func (f *Formatter) table(w *bufio.Writer, s *Stmt, err error) error {
+	hdr := s.Columns()
+	for i := range hdr {
+		hdr[i] = f.truncate(strings.Map(flatten, hdr[i]))
+	}
+	var rows [][]string
+	right := make([]bool, len(hdr))
+	for i := range right {
+		right[i] = true
+	}
+	err = f.rows(s, err, func(cells []string, num []bool) error {
+		row := make([]string, len(cells))
+		for i, v := range cells {
+			row[i] = f.truncate(v)
+			right[i] = right[i] && num[i]
+		}
+		rows = append(rows, row)
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	if len(rows) == 0 {
+		for i := range right {
+			right[i] = false
+		}
+	}
+
+	// Determine column widths
+	width := make([]int, len(hdr))
+	showHdr := !f.NoHeader || f.Mode == FormatMarkdown
+	if showHdr {
+		for i, v := range hdr {
+			width[i] = utf8.RuneCountInString(v)
+		}
+	}
+	for _, row := range rows {
+		for i, v := range row {
+			if n := utf8.RuneCountInString(v); n > width[i] {
+				width[i] = n
+			}
+		}
+	}
+
+	t := tableWriter{w: w, width: width, right: right}
+	switch f.Mode {
+	case FormatBox:
+		t.rule("┌─", "─┬─", "─┐")
+		if showHdr {
+			t.row("│ ", " │ ", " │", hdr, false)
+			t.rule("├─", "─┼─", "─┤")
+		}
+		for _, row := range rows {
+			t.row("│ ", " │ ", " │", row, true)
+		}
+		t.rule("└─", "─┴─", "─┘")
+	case FormatMarkdown:
+		for i := range width {
+			if width[i] < 3 {
+				width[i] = 3
+			}
+		}
+		t.escape = strings.NewReplacer("|", `\|`)
+		t.row("| ", " | ", " |", hdr, false)
+		t.w.WriteString("|")
+		for i, n := range width {
+			if right[i] {
+				t.w.WriteString(" " + strings.Repeat("-", n-1) + ": |")
+			} else {
+				t.w.WriteString(" " + strings.Repeat("-", n) + " |")
+			}
+		}
+		t.w.WriteByte('\n')
+		for _, row := range rows {
+			t.row("| ", " | ", " |", row, true)
+		}
+	default:
+		if showHdr {
+			t.row("", "  ", "", hdr, false)
+			t.rule("", "  ", "")
+		}
+		for _, row := range rows {
+			t.row("", "  ", "", row, true)
+		}
+	}
+	return nil
+}
+
+// truncate limits the length of v to f.MaxWidth characters.
+func (f *Formatter) truncate(v string) string {
+	if f.MaxWidth <= 0 || utf8.RuneCountInString(v) <= f.MaxWidth {
+		return v
+	}
+	n := 0
+	for i := range v {
+		if n++; n == f.MaxWidth {
+			return v[:i] + "…"
+		}
+	}
+	return v
+}
+
+// tableWriter writes rows of aligned columns.
+type tableWriter struct {
+	w      *bufio.Writer
+	width  []int
+	right  []bool
+	escape *strings.Replacer
+}
+
+// row writes one row of cells. Numeric columns are aligned to the right if
+// align is true.
+func (t *tableWriter) row(start, sep, end string, cells []string, align bool) {
+	var line []byte
+	line = append(line, start...)
+	for i, v := range cells {
+		if i > 0 {
+			line = append(line, sep...)
+		}
+		pad := t.width[i] - utf8.RuneCountInString(v)
+		if t.escape != nil {
+			v = t.escape.Replace(v)
+		}
+		if align && t.right[i] {
+			line = append(line, strings.Repeat(" ", pad)...)
+			line = append(line, v...)
+		} else {
+			line = append(line, v...)
+			line = append(line, strings.Repeat(" ", pad)...)
+		}
+	}
+	line = append(line, end...)
+	if end == "" {
+		line = []byte(strings.TrimRight(string(line), " "))
+	}
+	t.w.Write(line)
+	t.w.WriteByte('\n')
+}
+
+// rule writes a horizontal line.
+func (t *tableWriter) rule(start, sep, end string) {
+	dash := "─"
+	if start == "" {
+		dash = "-"
+	}
+	t.w.WriteString(start)
+	for i, n := range t.width {
+		if i > 0 {
+			t.w.WriteString(sep)
+		}
+		t.w.WriteString(strings.Repeat(dash, n))
+	}
+	t.w.WriteString(end)
+	t.w.WriteByte('\n')
+}
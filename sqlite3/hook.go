@@ -0,0 +1,79 @@
+// Copyright 2013 The Go-SQLite Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package sqlite3
+
+import (
+	"database/sql"
+	"sync"
+)
+
+// ConnectHook is a function that initializes a new connection (e.g. by setting
+// the codec key, registering functions, or executing PRAGMA statements). If it
+// returns an error, the connection is closed and the error is returned to the
+// caller that attempted to open it.
+type ConnectHook func(c *Conn) error
+
+// Registered connect hooks. The slices are never modified in place, so they
+// may be used without holding hookMu once they are obtained.
+var (
+	connHooks   []ConnectHook
+	driverHooks map[string]ConnectHook
+	hookMu      sync.Mutex
+)
+
+// RegisterConnectHook adds f to the list of functions that are called, in the
+// order of registration, for every connection created by Open and by all
+// database/sql drivers registered by this package. Hooks are called after the
+// connection is opened and before it is returned. Connections that were opened
+// before f was registered are not affected.
+func RegisterConnectHook(f ConnectHook) {
+	if f == nil {
+		return
+	}
+	hookMu.Lock()
+	defer hookMu.Unlock()
+	connHooks = append(connHooks[:len(connHooks):len(connHooks)], f)
+}
+
+// RegisterDriver registers a database/sql driver with the specified name. The
+// driver behaves like the default "sqlite3" driver, but each new connection is
+// also passed to f after all hooks registered by RegisterConnectHook have been
+// called. It panics if the name is already in use (see sql.Register).
+func RegisterDriver(name string, f ConnectHook) {
+	hookMu.Lock()
+	if f != nil {
+		if driverHooks == nil {
+			driverHooks = make(map[string]ConnectHook)
+		}
+		driverHooks[name] = f
+	}
+	hookMu.Unlock()
+	sql.Register(name, Driver(name))
+}
+
+// connect runs all connect hooks, followed by f, for a new connection. The
+// connection is closed if any of them fail.
+func (c *Conn) connect(f ConnectHook) error {
+	hookMu.Lock()
+	hooks := connHooks
+	hookMu.Unlock()
+	if f != nil {
+		hooks = append(hooks[:len(hooks):len(hooks)], f)
+	}
+	for _, hook := range hooks {
+		if err := hook(c); err != nil {
+			c.Close()
+			return err
+		}
+	}
+	return nil
+}
+
+// driverHook returns the connect hook for the specified driver name.
+func driverHook(name string) ConnectHook {
+	hookMu.Lock()
+	defer hookMu.Unlock()
+	return driverHooks[name]
+}
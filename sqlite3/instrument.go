@@ -0,0 +1,405 @@
+// Copyright 2013 The Go-SQLite Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package sqlite3
+
+import (
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Instrument receives notifications about the execution of prepared statements
+// on a connection. A run of a statement begins with a call to Exec or Query
+// (including Conn.Exec and Conn.Query) and ends when the statement is reset
+// after the last row or an error, or by a call to Reset, Exec, Query, or Close.
+// The methods are called by the goroutine that is using the statement and must
+// not execute any other statements on the same connection.
+type Instrument interface {
+	// Begin is called before the first step of a new run.
+	Begin(s *Stmt)
+
+	// End is called when the run ends. The Instrument may retain r.
+	End(s *Stmt, r *StmtRun)
+}
+
+// StmtRun describes one run of a prepared statement. The counters only include
+// the work performed during this run (see Stmt.Status). If a counter is reset
+// during the run, only the work performed after the reset is included.
+type StmtRun struct {
+	SQL       string        // Statement text (see Stmt.String)
+	Start     time.Time     // Time when the run began
+	Duration  time.Duration // Time spent in SQLite (excludes time between Next calls)
+	Rows      int           // Number of rows returned
+	FullScan  int           // STMTSTATUS_FULLSCAN_STEP
+	Sort      int           // STMTSTATUS_SORT
+	AutoIndex int           // STMTSTATUS_AUTOINDEX
+	VMStep    int           // STMTSTATUS_VM_STEP
+	Err       error         // Error that ended the run, if any
+}
+
+// stmtCounters are the STMTSTATUS counters reported in StmtRun.
+var stmtCounters = [...]int{
+	STMTSTATUS_FULLSCAN_STEP,
+	STMTSTATUS_SORT,
+	STMTSTATUS_AUTOINDEX,
+	STMTSTATUS_VM_STEP,
+}
+
+// stmtRun is the instrumentation state of a statement that is being executed.
+type stmtRun struct {
+	StmtRun
+	instr Instrument
+	base  [len(stmtCounters)]int // Counter values when the run began
+}
+
+// Instrument registers an Instrument for all statements executed on this
+// connection, including those that are already prepared. It returns the
+// previous Instrument, if any. Nil disables instrumentation.
+func (c *Conn) Instrument(i Instrument) (prev Instrument) {
+	if c.db != nil {
+		prev, c.instr = c.instr, i
+	}
+	return
+}
+
+// begin starts a new run of s.
+func (s *Stmt) begin(instr Instrument) {
+	instr.Begin(s)
+	r := &stmtRun{instr: instr}
+	r.SQL = s.text
+	for i, op := range stmtCounters {
+		r.base[i] = s.Status(op, false)
+	}
+	r.Start = time.Now()
+	s.run = r
+}
+
+// end finishes the current run of s.
+func (s *Stmt) end(err error) {
+	r := s.run
+	s.run = nil
+	var n [len(stmtCounters)]int
+	for i, op := range stmtCounters {
+		if n[i] = s.Status(op, false); n[i] >= r.base[i] {
+			n[i] -= r.base[i]
+		}
+	}
+	r.FullScan, r.Sort, r.AutoIndex, r.VMStep = n[0], n[1], n[2], n[3]
+	r.Err = err
+	r.instr.End(s, &r.StmtRun)
+}
+
+// QueryStats contains the aggregated StmtRun values of all queries with the
+// same normalized SQL text.
+type QueryStats struct {
+	SQL       string        // Normalized SQL text (see NormalizeSQL)
+	Count     int           // Number of runs
+	Errors    int           // Number of runs that ended with an error
+	Total     time.Duration // Total time spent in SQLite
+	Max       time.Duration // Duration of the slowest run
+	Rows      int64
+	FullScan  int64
+	Sort      int64
+	AutoIndex int64
+	VMStep    int64
+}
+
+// Mean returns the average duration of one run.
+func (q *QueryStats) Mean() time.Duration {
+	if q.Count == 0 {
+		return 0
+	}
+	return q.Total / time.Duration(q.Count)
+}
+
+// Collector is an Instrument that aggregates statement runs by normalized SQL
+// text. The same Collector may be used by multiple connections concurrently,
+// for example by registering it with every connection in a connect hook:
+//
+// 	col := sqlite3.NewCollector(1000)
+// 	sqlite3.RegisterConnectHook(func(c *sqlite3.Conn) error {
+// 		c.Instrument(col)
+// 		return nil
+// 	})
+type Collector struct {
+	mu      sync.Mutex
+	max     int
+	queries map[string]*QueryStats
+	dropped int
+}
+
+// NewCollector returns a new Collector that keeps statistics for at most max
+// distinct queries (0 = no limit). Runs of new queries are discarded once this
+// limit is reached.
+func NewCollector(max int) *Collector {
+	return &Collector{max: max, queries: make(map[string]*QueryStats)}
+}
+
+// Begin implements the Instrument interface.
+func (col *Collector) Begin(s *Stmt) {}
+
+// End implements the Instrument interface.
+func (col *Collector) End(s *Stmt, r *StmtRun) {
+	sql := NormalizeSQL(r.SQL)
+	col.mu.Lock()
+	defer col.mu.Unlock()
+	q := col.queries[sql]
+	if q == nil {
+		if col.max > 0 && len(col.queries) >= col.max {
+			col.dropped++
+			return
+		}
+		q = &QueryStats{SQL: sql}
+		col.queries[sql] = q
+	}
+	q.Count++
+	if r.Err != nil {
+		q.Errors++
+	}
+	q.Total += r.Duration
+	if r.Duration > q.Max {
+		q.Max = r.Duration
+	}
+	q.Rows += int64(r.Rows)
+	q.FullScan += int64(r.FullScan)
+	q.Sort += int64(r.Sort)
+	q.AutoIndex += int64(r.AutoIndex)
+	q.VMStep += int64(r.VMStep)
+}
+
+// Queries returns a copy of the statistics for all queries, in no particular
+// order, and the number of runs that were discarded because of the query
+// limit.
+func (col *Collector) Queries() (qs []*QueryStats, dropped int) {
+	col.mu.Lock()
+	defer col.mu.Unlock()
+	qs = make([]*QueryStats, 0, len(col.queries))
+	for _, q := range col.queries {
+		cp := *q
+		qs = append(qs, &cp)
+	}
+	return qs, col.dropped
+}
+
+// Slowest returns at most n queries with the highest total time spent in
+// SQLite, in descending order. All queries are returned if n <= 0.
+func (col *Collector) Slowest(n int) []*QueryStats {
+	qs, _ := col.Queries()
+	return top(qs, n, func(q *QueryStats) int64 { return int64(q.Total) })
+}
+
+// FullScans returns at most n queries that performed full table scans, ordered
+// by the number of full scan steps in descending order. All such queries are
+// returned if n <= 0.
+func (col *Collector) FullScans(n int) []*QueryStats {
+	qs, _ := col.Queries()
+	return top(qs, n, func(q *QueryStats) int64 { return q.FullScan })
+}
+
+// Reset discards all statistics.
+func (col *Collector) Reset() {
+	col.mu.Lock()
+	defer col.mu.Unlock()
+	col.queries = make(map[string]*QueryStats)
+	col.dropped = 0
+}
+
+// top returns at most n queries with the highest non-zero keys, sorted by key
+// in descending order and then by SQL text.
+func top(qs []*QueryStats, n int, key func(q *QueryStats) int64) []*QueryStats {
+	out := qs[:0]
+	for _, q := range qs {
+		if key(q) > 0 {
+			out = append(out, q)
+		}
+	}
+	sort.Slice(out, func(i, j int) bool {
+		if a, b := key(out[i]), key(out[j]); a != b {
+			return a > b
+		}
+		return out[i].SQL < out[j].SQL
+	})
+	if n > 0 && len(out) > n {
+		out = out[:n]
+	}
+	return out
+}
+
+// NormalizeSQL returns sql with all literals and parameters replaced by "?",
+// comments removed, keywords converted to upper case, whitespace between tokens
+// standardized, and lists of consecutive "?" values (e.g. "IN (1, 2, 3)")
+// reduced to a single "?". Signs of numeric literals are considered part of the
+// literal (e.g. "a = -1" becomes "a = ?"). Statements that differ only in these
+// respects have the same normalized text.
+func NormalizeSQL(sql string) string {
+	var b []byte
+	sep := false
+
+	// State of the last two tokens, which allows the last one to be removed
+	var last, prev string
+	lastPos, lastSep := 0, false
+
+	emit := func(tok string) {
+		lastPos, lastSep = len(b), sep
+		switch tok {
+		case ",", ";", ")", ".":
+		default:
+			if sep {
+				b = append(b, ' ')
+			}
+		}
+		b = append(b, tok...)
+		sep = tok != "(" && tok != "."
+		prev, last = last, tok
+	}
+	param := func() {
+		// Collapse "?, ?" into "?"
+		if n := len(b); n >= 2 && b[n-1] == ',' && b[n-2] == '?' {
+			b, sep = b[:n-1], true
+			last = "?"
+			return
+		}
+		emit("?")
+	}
+	for i := 0; i < len(sql); {
+		c := sql[i]
+		switch {
+		case c == ' ' || c == '\t' || c == '\n' || c == '\r' || c == '\f':
+			i++
+		case strings.HasPrefix(sql[i:], "--"):
+			if j := strings.IndexByte(sql[i:], '\n'); j >= 0 {
+				i += j + 1
+			} else {
+				i = len(sql)
+			}
+		case strings.HasPrefix(sql[i:], "/*"):
+			if j := strings.Index(sql[i+2:], "*/"); j >= 0 {
+				i += j + 4
+			} else {
+				i = len(sql)
+			}
+		case c == '\'' || ((c == 'x' || c == 'X') && strings.HasPrefix(sql[i+1:], "'")):
+			if c != '\'' {
+				i++
+			}
+			i += quotedLen(sql[i:], '\'')
+			param()
+		case c == '"' || c == '`' || c == '[':
+			end := c
+			if c == '[' {
+				end = ']'
+			}
+			n := quotedLen(sql[i:], end)
+			emit(sql[i : i+n])
+			i += n
+		case isDigit(c) || (c == '.' && i+1 < len(sql) && isDigit(sql[i+1])):
+			for i++; i < len(sql); i++ {
+				if c = sql[i]; !isIdent(c) && c != '.' && !((c == '+' || c == '-') &&
+					(sql[i-1] == 'e' || sql[i-1] == 'E')) {
+					break
+				}
+			}
+			if (last == "-" || last == "+") && !isOperand(prev) {
+				b, sep, last = b[:lastPos], lastSep, prev // Unary sign
+			}
+			param()
+		case c == '?' || ((c == ':' || c == '@' || c == '$') && i+1 < len(sql) &&
+			isIdent(sql[i+1])):
+			for i++; i < len(sql) && isIdent(sql[i]); i++ {
+			}
+			param()
+		case isIdent(c):
+			j := i
+			for i++; i < len(sql) && isIdent(sql[i]); i++ {
+			}
+			tok := sql[j:i]
+			if kw := strings.ToUpper(tok); keywords[kw] {
+				tok = kw
+			}
+			emit(tok)
+		default:
+			n := 1
+			for _, op := range operators {
+				if strings.HasPrefix(sql[i:], op) {
+					n = len(op)
+					break
+				}
+			}
+			emit(sql[i : i+n])
+			i += n
+		}
+	}
+	return strings.TrimRight(string(b), ";")
+}
+
+// isOperand returns true if the normalized token tok can be the left operand of
+// a binary operator.
+func isOperand(tok string) bool {
+	switch {
+	case tok == "":
+		return false
+	case tok == ")" || tok == "?" || tok == "NULL" || tok == "END" ||
+		strings.HasPrefix(tok, "CURRENT_"):
+		return true
+	case tok[0] == '"' || tok[0] == '`' || tok[0] == '[':
+		return true
+	}
+	return isIdent(tok[0]) && !keywords[tok]
+}
+
+// operators are the multi-character SQL operators, longest first.
+var operators = []string{"->>", "->", "<=", ">=", "<>", "!=", "==", "||", "<<", ">>"}
+
+// keywords are the SQLite keywords that NormalizeSQL converts to upper case.
+// [http://www.sqlite.org/lang_keywords.html]
+var keywords = make(map[string]bool)
+
+func init() {
+	for _, kw := range strings.Fields(`
+		ABORT ACTION ADD AFTER ALL ALTER ALWAYS ANALYZE AND AS ASC ATTACH
+		AUTOINCREMENT BEFORE BEGIN BETWEEN BY CASCADE CASE CAST CHECK COLLATE
+		COLUMN COMMIT CONFLICT CONSTRAINT CREATE CROSS CURRENT CURRENT_DATE
+		CURRENT_TIME CURRENT_TIMESTAMP DATABASE DEFAULT DEFERRABLE DEFERRED
+		DELETE DESC DETACH DISTINCT DO DROP EACH ELSE END ESCAPE EXCEPT EXCLUDE
+		EXCLUSIVE EXISTS EXPLAIN FAIL FILTER FIRST FOLLOWING FOR FOREIGN FROM
+		FULL GENERATED GLOB GROUP GROUPS HAVING IF IGNORE IMMEDIATE IN INDEX
+		INDEXED INITIALLY INNER INSERT INSTEAD INTERSECT INTO IS ISNULL JOIN KEY
+		LAST LEFT LIKE LIMIT MATCH MATERIALIZED NATURAL NO NOT NOTHING NOTNULL
+		NULL NULLS OF OFFSET ON OR ORDER OTHERS OUTER OVER PARTITION PLAN
+		PRAGMA PRECEDING PRIMARY QUERY RAISE RANGE RECURSIVE REFERENCES REGEXP
+		REINDEX RELEASE RENAME REPLACE RESTRICT RETURNING RIGHT ROLLBACK ROW
+		ROWS SAVEPOINT SELECT SET TABLE TEMP TEMPORARY THEN TIES TO TRANSACTION
+		TRIGGER UNBOUNDED UNION UNIQUE UPDATE USING VACUUM VALUES VIEW VIRTUAL
+		WHEN WHERE WINDOW WITH WITHOUT`) {
+		keywords[kw] = true
+	}
+}
+
+// quotedLen returns the length of the quoted string or identifier at the start
+// of s, including the quotes. Doubled end characters are treated as escapes.
+func quotedLen(s string, end byte) int {
+	for i := 1; i < len(s); i++ {
+		if s[i] == end {
+			if i+1 < len(s) && s[i+1] == end && end != ']' {
+				i++
+				continue
+			}
+			return i + 1
+		}
+	}
+	return len(s)
+}
+
+// isDigit returns true if c is an ASCII digit.
+func isDigit(c byte) bool {
+	return '0' <= c && c <= '9'
+}
+
+// isIdent returns true if c may be part of an unquoted identifier.
+func isIdent(c byte) bool {
+	return c == '_' || c == '$' || isDigit(c) || c >= 0x80 ||
+		('a' <= c && c <= 'z') || ('A' <= c && c <= 'Z')
+}
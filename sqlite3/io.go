@@ -16,7 +16,7 @@ import (
 
 // ErrBlobFull is returned by BlobIO.Write when there isn't enough space left to
 // write the provided bytes.
-var ErrBlobFull = &Error{ERROR, "incremental write failed, no space left"}
+var ErrBlobFull = &Error{rc: ERROR, msg: "incremental write failed, no space left"}
 
 // BlobIO is a handle to a single BLOB (binary large object) or TEXT value
 // opened for incremental I/O. This allows the value to be treated as a file for
@@ -52,6 +52,7 @@ func newBlobIO(c *Conn, db, tbl, col string, row int64, rw bool) (*BlobIO, error
 		row:  row,
 		len:  int(C.sqlite3_blob_bytes(blob)),
 	}
+	c.ref()
 	runtime.SetFinalizer(b, (*BlobIO).Close)
 	return b, nil
 }
@@ -67,7 +68,9 @@ func (b *BlobIO) Close() error {
 		b.len = 0
 		b.off = 0
 		runtime.SetFinalizer(b, nil)
-		if rc := C.sqlite3_blob_close(blob); rc != OK {
+		rc := C.sqlite3_blob_close(blob)
+		b.conn.unref()
+		if rc != OK {
 			return libErr(rc, b.conn.db)
 		}
 	}
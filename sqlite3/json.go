@@ -0,0 +1,125 @@
+// Copyright 2013 The Go-SQLite Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package sqlite3
+
+import (
+	"bufio"
+	"database/sql/driver"
+	"encoding/json"
+	"io"
+)
+
+// Output formats for Stmt.WriteJSON.
+const (
+	JSONArray = iota // Single array containing one object per row
+	JSONLines        // One object per line (newline-delimited JSON)
+)
+
+// JSON is a special argument type for storing Go values as JSON text. When used
+// as an argument to a statement, V is encoded by json.Marshal and bound as TEXT.
+// When used for retrieving query output, the column value is decoded into V by
+// json.Unmarshal, so V must be a pointer (e.g. s.Scan(JSON{&v})). NULL is
+// decoded as JSON null, which sets pointers, maps, and slices to nil and leaves
+// other values unchanged.
+//
+// Values that implement json.Marshaler or json.Unmarshaler (other than
+// time.Time) are handled the same way without the wrapper. JSON also implements
+// driver.Valuer and sql.Scanner for use with the database/sql package.
+type JSON struct {
+	V interface{}
+}
+
+// Value implements the driver.Valuer interface.
+func (j JSON) Value() (driver.Value, error) {
+	b, err := json.Marshal(j.V)
+	if err != nil {
+		return nil, err
+	}
+	return string(b), nil
+}
+
+// Scan implements the sql.Scanner interface.
+func (j *JSON) Scan(src interface{}) error {
+	switch src := src.(type) {
+	case nil:
+		return json.Unmarshal([]byte("null"), j.V)
+	case string:
+		return json.Unmarshal([]byte(src), j.V)
+	case []byte:
+		return json.Unmarshal(src, j.V)
+	}
+	return pkgErr(MISUSE, "cannot decode %T as JSON", src)
+}
+
+// WriteJSON writes all remaining rows returned by s to w as JSON objects, using
+// column names as keys. The format is either JSONArray or JSONLines. Column
+// values are converted using the same rules as *interface{} Scan arguments (see
+// the package documentation), so BLOBs are encoded as base64 strings and
+// DATE/TIME columns as RFC 3339 strings. The statement is executed first if it
+// is not already busy.
+func (s *Stmt) WriteJSON(w io.Writer, format int) error {
+	if s.stmt == nil {
+		return ErrBadStmt
+	}
+	if format != JSONArray && format != JSONLines {
+		return pkgErr(MISUSE, "invalid JSON format (%d)", format)
+	}
+	var err error
+	if !s.Busy() {
+		if err = s.Query(); err != nil && err != io.EOF {
+			return err
+		}
+	}
+	keys := make([][]byte, s.nCols)
+	for i, col := range s.Columns() {
+		k, err := json.Marshal(col)
+		if err != nil {
+			return err
+		}
+		keys[i] = k
+	}
+	vals := make([]interface{}, s.nCols)
+	dst := make([]interface{}, s.nCols)
+	for i := range vals {
+		dst[i] = &vals[i]
+	}
+
+	bw := bufio.NewWriter(w)
+	if format == JSONArray {
+		bw.WriteByte('[')
+	}
+	for n := 0; err == nil; err = s.Next() {
+		if err = s.Scan(dst...); err != nil {
+			return err
+		}
+		if n++; format == JSONArray && n > 1 {
+			bw.WriteByte(',')
+		}
+		bw.WriteByte('{')
+		for i, v := range vals {
+			if i > 0 {
+				bw.WriteByte(',')
+			}
+			b, err := json.Marshal(v)
+			if err != nil {
+				return err
+			}
+			bw.Write(keys[i])
+			bw.WriteByte(':')
+			bw.Write(b)
+		}
+		bw.WriteByte('}')
+		if format == JSONLines {
+			bw.WriteByte('\n')
+		}
+	}
+	if err != io.EOF {
+		return err
+	}
+	if format == JSONArray {
+		bw.WriteString("]\n")
+	}
+	return bw.Flush()
+}
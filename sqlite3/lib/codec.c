@@ -114,4 +114,28 @@ void sqlite3CodecGetKey(sqlite3 *db, int iDb, void **pKey, int *nKey) {
 	}
 }
 
+// codec_page_info returns the page size and the number of bytes reserved at the
+// end of each page of the specified database, whether the database has a codec
+// attached, and whether its schema is loaded. The page size and reserve values
+// are not read from the database file until the schema is loaded.
+int codec_page_info(sqlite3 *db, const char *zDb, int *nBuf, int *nRes, int *hasCodec, int *loaded) {
+	int iDb = 0;
+	int rc = SQLITE_OK;
+	sqlite3_mutex_enter(db->mutex);
+	if (zDb && zDb[0]) {
+		iDb = sqlite3FindDbName(db, zDb);
+	}
+	if (iDb < 0 || db->aDb[iDb].pBt == 0) {
+		rc = SQLITE_ERROR;
+	} else {
+		Btree *pBt = db->aDb[iDb].pBt;
+		*nBuf = sqlite3BtreeGetPageSize(pBt);
+		*nRes = sqlite3BtreeGetReserve(pBt);
+		*hasCodec = sqlite3PagerGetCodec(sqlite3BtreePager(pBt)) != 0;
+		*loaded = DbHasProperty(db, iDb, DB_SchemaLoaded);
+	}
+	sqlite3_mutex_leave(db->mutex);
+	return rc;
+}
+
 #endif
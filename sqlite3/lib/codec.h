@@ -21,4 +21,7 @@ typedef struct CodecCtx {
 int sqlite3CodecAttach(sqlite3*,int,const void*,int);
 void sqlite3CodecGetKey(sqlite3*,int,void**,int*);
 
+// Page layout information for codec-aware backups.
+int codec_page_info(sqlite3*,const char*,int*,int*,int*,int*);
+
 #endif
@@ -0,0 +1,351 @@
+// Copyright 2013 The Go-SQLite Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// memvfs is a minimal VFS that keeps all files in memory. It exists because
+// this version of SQLite does not provide sqlite3_serialize and
+// sqlite3_deserialize. Each file is shared by all handles that open the same
+// name and is freed when the last handle is closed. Locking is not implemented,
+// so a file must not be used by more than one connection at a time.
+
+#include <string.h>
+
+#include "memvfs.h"
+
+typedef struct MemvfsFile MemvfsFile;
+typedef struct MemvfsHandle MemvfsHandle;
+
+// MemvfsFile is the contents of one in-memory file.
+struct MemvfsFile {
+	MemvfsFile *pNext;     // Next file in memvfsFiles
+	char *zName;           // File name or NULL for anonymous temporary files
+	unsigned char *aData;  // File contents
+	sqlite3_int64 nData;   // File size
+	sqlite3_int64 nAlloc;  // Size of aData
+	int nRef;              // Number of open handles and memvfs_put references
+	int linked;            // File is in memvfsFiles
+};
+
+// MemvfsHandle is an open file handle.
+struct MemvfsHandle {
+	sqlite3_file base;
+	MemvfsFile *pFile;
+};
+
+// memvfsFiles is the list of all named files, protected by memvfsMutex.
+static MemvfsFile *memvfsFiles;
+static sqlite3_mutex *memvfsMutex;
+
+// memvfsFind returns the named file or NULL if it does not exist.
+static MemvfsFile *memvfsFind(const char *zName) {
+	MemvfsFile *p = memvfsFiles;
+	while (p && strcmp(p->zName, zName) != 0) {
+		p = p->pNext;
+	}
+	return p;
+}
+
+// memvfsNew creates a new file and adds it to memvfsFiles if zName is not NULL.
+static MemvfsFile *memvfsNew(const char *zName) {
+	MemvfsFile *p = sqlite3_malloc(sizeof(*p));
+	if (p == 0) return 0;
+	memset(p, 0, sizeof(*p));
+	if (zName) {
+		if ((p->zName = sqlite3_mprintf("%s", zName)) == 0) {
+			sqlite3_free(p);
+			return 0;
+		}
+		p->pNext = memvfsFiles;
+		p->linked = 1;
+		memvfsFiles = p;
+	}
+	return p;
+}
+
+// memvfsUnlink removes the file from memvfsFiles.
+static void memvfsUnlink(MemvfsFile *p) {
+	MemvfsFile **pp = &memvfsFiles;
+	if (!p->linked) return;
+	while (*pp != p) {
+		pp = &(*pp)->pNext;
+	}
+	*pp = p->pNext;
+	p->linked = 0;
+}
+
+// memvfsUnref releases one reference to the file and frees it when there are
+// none left.
+static void memvfsUnref(MemvfsFile *p) {
+	if (--p->nRef > 0) return;
+	memvfsUnlink(p);
+	sqlite3_free(p->aData);
+	sqlite3_free(p->zName);
+	sqlite3_free(p);
+}
+
+// memvfsResize ensures that the file can hold at least n bytes.
+static int memvfsResize(MemvfsFile *p, sqlite3_int64 n) {
+	if (n > p->nAlloc) {
+		sqlite3_int64 nAlloc = p->nAlloc ? p->nAlloc : 4096;
+		unsigned char *aData;
+		while (nAlloc < n) {
+			nAlloc *= 2;
+		}
+		if (nAlloc > 0x7fffffff) return SQLITE_FULL;
+		if ((aData = sqlite3_realloc(p->aData, (int)nAlloc)) == 0) {
+			return SQLITE_IOERR_NOMEM;
+		}
+		p->aData = aData;
+		p->nAlloc = nAlloc;
+	}
+	return SQLITE_OK;
+}
+
+static int memvfsClose(sqlite3_file *pFile) {
+	MemvfsHandle *h = (MemvfsHandle*)pFile;
+	sqlite3_mutex_enter(memvfsMutex);
+	memvfsUnref(h->pFile);
+	sqlite3_mutex_leave(memvfsMutex);
+	h->pFile = 0;
+	return SQLITE_OK;
+}
+
+static int memvfsRead(sqlite3_file *pFile, void *pBuf, int iAmt, sqlite3_int64 iOfst) {
+	MemvfsFile *p = ((MemvfsHandle*)pFile)->pFile;
+	if (iOfst + iAmt > p->nData) {
+		int n = iOfst < p->nData ? (int)(p->nData - iOfst) : 0;
+		if (n > 0) memcpy(pBuf, p->aData + iOfst, n);
+		memset((char*)pBuf + n, 0, iAmt - n);
+		return SQLITE_IOERR_SHORT_READ;
+	}
+	memcpy(pBuf, p->aData + iOfst, iAmt);
+	return SQLITE_OK;
+}
+
+static int memvfsWrite(sqlite3_file *pFile, const void *pBuf, int iAmt, sqlite3_int64 iOfst) {
+	MemvfsFile *p = ((MemvfsHandle*)pFile)->pFile;
+	int rc = memvfsResize(p, iOfst + iAmt);
+	if (rc != SQLITE_OK) return rc;
+	if (iOfst > p->nData) {
+		memset(p->aData + p->nData, 0, iOfst - p->nData);
+	}
+	memcpy(p->aData + iOfst, pBuf, iAmt);
+	if (iOfst + iAmt > p->nData) {
+		p->nData = iOfst + iAmt;
+	}
+	return SQLITE_OK;
+}
+
+static int memvfsTruncate(sqlite3_file *pFile, sqlite3_int64 size) {
+	MemvfsFile *p = ((MemvfsHandle*)pFile)->pFile;
+	if (size < p->nData) {
+		p->nData = size;
+	}
+	return SQLITE_OK;
+}
+
+static int memvfsSync(sqlite3_file *pFile, int flags) {
+	return SQLITE_OK;
+}
+
+static int memvfsFileSize(sqlite3_file *pFile, sqlite3_int64 *pSize) {
+	*pSize = ((MemvfsHandle*)pFile)->pFile->nData;
+	return SQLITE_OK;
+}
+
+static int memvfsLock(sqlite3_file *pFile, int eLock) {
+	return SQLITE_OK;
+}
+
+static int memvfsCheckReservedLock(sqlite3_file *pFile, int *pResOut) {
+	*pResOut = 0;
+	return SQLITE_OK;
+}
+
+static int memvfsFileControl(sqlite3_file *pFile, int op, void *pArg) {
+	return SQLITE_NOTFOUND;
+}
+
+static int memvfsSectorSize(sqlite3_file *pFile) {
+	return 0;
+}
+
+static int memvfsDeviceCharacteristics(sqlite3_file *pFile) {
+	return 0;
+}
+
+static const sqlite3_io_methods memvfsIoMethods = {
+	1,                            // iVersion (no shared memory, so no WAL)
+	memvfsClose,
+	memvfsRead,
+	memvfsWrite,
+	memvfsTruncate,
+	memvfsSync,
+	memvfsFileSize,
+	memvfsLock,
+	memvfsLock,                   // xUnlock
+	memvfsCheckReservedLock,
+	memvfsFileControl,
+	memvfsSectorSize,
+	memvfsDeviceCharacteristics
+};
+
+static int memvfsOpen(sqlite3_vfs *pVfs, const char *zName, sqlite3_file *pFile, int flags, int *pOutFlags) {
+	MemvfsHandle *h = (MemvfsHandle*)pFile;
+	MemvfsFile *p;
+	int rc = SQLITE_OK;
+	h->base.pMethods = 0;
+	sqlite3_mutex_enter(memvfsMutex);
+	if ((p = zName ? memvfsFind(zName) : 0) == 0) {
+		if (zName && (flags & SQLITE_OPEN_CREATE) == 0) {
+			rc = SQLITE_CANTOPEN;
+		} else if ((p = memvfsNew(zName)) == 0) {
+			rc = SQLITE_NOMEM;
+		}
+	}
+	if (rc == SQLITE_OK) {
+		p->nRef++;
+		h->pFile = p;
+		h->base.pMethods = &memvfsIoMethods;
+		if (pOutFlags) *pOutFlags = flags;
+	}
+	sqlite3_mutex_leave(memvfsMutex);
+	return rc;
+}
+
+static int memvfsDelete(sqlite3_vfs *pVfs, const char *zName, int syncDir) {
+	MemvfsFile *p;
+	sqlite3_mutex_enter(memvfsMutex);
+	if ((p = memvfsFind(zName)) != 0) {
+		memvfsUnlink(p); // Freed when the last handle is closed
+	}
+	sqlite3_mutex_leave(memvfsMutex);
+	return SQLITE_OK;
+}
+
+static int memvfsAccess(sqlite3_vfs *pVfs, const char *zName, int flags, int *pResOut) {
+	sqlite3_mutex_enter(memvfsMutex);
+	*pResOut = memvfsFind(zName) != 0;
+	sqlite3_mutex_leave(memvfsMutex);
+	return SQLITE_OK;
+}
+
+static int memvfsFullPathname(sqlite3_vfs *pVfs, const char *zName, int nOut, char *zOut) {
+	sqlite3_snprintf(nOut, zOut, "%s", zName);
+	return SQLITE_OK;
+}
+
+// The remaining methods are delegated to the default VFS.
+#define ROOT_VFS(p) ((sqlite3_vfs*)((p)->pAppData))
+
+static void *memvfsDlOpen(sqlite3_vfs *pVfs, const char *zPath) {
+	return ROOT_VFS(pVfs)->xDlOpen(ROOT_VFS(pVfs), zPath);
+}
+
+static void memvfsDlError(sqlite3_vfs *pVfs, int nByte, char *zErrMsg) {
+	ROOT_VFS(pVfs)->xDlError(ROOT_VFS(pVfs), nByte, zErrMsg);
+}
+
+static void (*memvfsDlSym(sqlite3_vfs *pVfs, void *p, const char *zSym))(void) {
+	return ROOT_VFS(pVfs)->xDlSym(ROOT_VFS(pVfs), p, zSym);
+}
+
+static void memvfsDlClose(sqlite3_vfs *pVfs, void *p) {
+	ROOT_VFS(pVfs)->xDlClose(ROOT_VFS(pVfs), p);
+}
+
+static int memvfsRandomness(sqlite3_vfs *pVfs, int nByte, char *zOut) {
+	return ROOT_VFS(pVfs)->xRandomness(ROOT_VFS(pVfs), nByte, zOut);
+}
+
+static int memvfsSleep(sqlite3_vfs *pVfs, int nMicro) {
+	return ROOT_VFS(pVfs)->xSleep(ROOT_VFS(pVfs), nMicro);
+}
+
+static int memvfsCurrentTime(sqlite3_vfs *pVfs, double *pTime) {
+	return ROOT_VFS(pVfs)->xCurrentTime(ROOT_VFS(pVfs), pTime);
+}
+
+static int memvfsGetLastError(sqlite3_vfs *pVfs, int nByte, char *zOut) {
+	return 0;
+}
+
+static sqlite3_vfs memvfs = {
+	1,                       // iVersion
+	sizeof(MemvfsHandle),    // szOsFile
+	512,                     // mxPathname
+	0,                       // pNext
+	MEMVFS_NAME,             // zName
+	0,                       // pAppData (default VFS)
+	memvfsOpen,
+	memvfsDelete,
+	memvfsAccess,
+	memvfsFullPathname,
+	memvfsDlOpen,
+	memvfsDlError,
+	memvfsDlSym,
+	memvfsDlClose,
+	memvfsRandomness,
+	memvfsSleep,
+	memvfsCurrentTime,
+	memvfsGetLastError
+};
+
+// memvfs_register registers the in-memory VFS without making it the default.
+int memvfs_register(void) {
+	if (memvfs.pAppData) return SQLITE_OK;
+	if ((memvfs.pAppData = sqlite3_vfs_find(0)) == 0) return SQLITE_ERROR;
+	if ((memvfsMutex = sqlite3_mutex_alloc(SQLITE_MUTEX_FAST)) == 0) {
+		return SQLITE_NOMEM;
+	}
+	return sqlite3_vfs_register(&memvfs, 0);
+}
+
+// memvfs_put creates a new file with a copy of the given data. The caller must
+// release its reference by calling memvfs_release.
+int memvfs_put(const char *zName, const void *pData, sqlite3_int64 nData) {
+	MemvfsFile *p;
+	int rc = SQLITE_OK;
+	sqlite3_mutex_enter(memvfsMutex);
+	if (memvfsFind(zName)) {
+		rc = SQLITE_CANTOPEN;
+	} else if ((p = memvfsNew(zName)) == 0) {
+		rc = SQLITE_NOMEM;
+	} else {
+		p->nRef = 1;
+		if ((rc = memvfsResize(p, nData)) == SQLITE_OK) {
+			if (nData > 0) memcpy(p->aData, pData, nData);
+			p->nData = nData;
+		} else {
+			memvfsUnref(p);
+		}
+	}
+	sqlite3_mutex_leave(memvfsMutex);
+	return rc;
+}
+
+// memvfs_data returns the current contents of an open file. The pointer remains
+// valid until the file is modified or closed.
+int memvfs_data(const char *zName, const void **pData, sqlite3_int64 *nData) {
+	MemvfsFile *p;
+	int rc = SQLITE_OK;
+	sqlite3_mutex_enter(memvfsMutex);
+	if ((p = memvfsFind(zName)) == 0) {
+		rc = SQLITE_NOTFOUND;
+	} else {
+		*pData = p->aData;
+		*nData = p->nData;
+	}
+	sqlite3_mutex_leave(memvfsMutex);
+	return rc;
+}
+
+// memvfs_release releases the reference acquired by memvfs_put.
+void memvfs_release(const char *zName) {
+	MemvfsFile *p;
+	sqlite3_mutex_enter(memvfsMutex);
+	if ((p = memvfsFind(zName)) != 0) {
+		memvfsUnref(p);
+	}
+	sqlite3_mutex_leave(memvfsMutex);
+}
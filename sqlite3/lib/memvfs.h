@@ -0,0 +1,17 @@
+// Copyright 2013 The Go-SQLite Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+#ifndef _MEMVFS_H_
+#define _MEMVFS_H_
+
+// Name of the in-memory VFS used by Conn.Serialize and Conn.Deserialize.
+#define MEMVFS_NAME "go-sqlite-mem"
+
+// In-memory VFS interface.
+int memvfs_register(void);
+int memvfs_put(const char*,const void*,sqlite3_int64);
+int memvfs_data(const char*,const void**,sqlite3_int64*);
+void memvfs_release(const char*);
+
+#endif
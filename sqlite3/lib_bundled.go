@@ -0,0 +1,13 @@
+// Copyright 2013 The Go-SQLite Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+//go:build !libsqlite3
+
+package sqlite3
+
+/*
+// The bundled amalgamation is compiled with codec support (see lib/codec.c).
+#cgo CFLAGS: -DSQLITE_HAS_CODEC=1
+*/
+import "C"
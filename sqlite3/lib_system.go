@@ -0,0 +1,16 @@
+// Copyright 2013 The Go-SQLite Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+//go:build libsqlite3
+
+package sqlite3
+
+/*
+// Link with the system SQLite library instead of compiling the bundled
+// amalgamation. Codec support is disabled because lib/codec.c depends on SQLite
+// internals, so Conn.Key and Conn.Rekey return ErrNoCodec.
+#cgo CFLAGS: -DUSE_LIBSQLITE3=1
+#cgo pkg-config: sqlite3
+*/
+import "C"
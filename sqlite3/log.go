@@ -0,0 +1,43 @@
+// Copyright 2013 The Go-SQLite Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package sqlite3
+
+import "sync"
+
+// LogFunc receives messages from the SQLite error log. The code is a primary
+// or extended result code, such as NOTICE_RECOVER_WAL, WARNING_AUTOINDEX, or
+// the code of an error that was returned to the caller.
+type LogFunc func(code int, msg string)
+
+// Current error log callback.
+var (
+	logger   LogFunc
+	loggerMu sync.RWMutex
+)
+
+// SetLogger installs f as the receiver of the SQLite error log, which contains
+// warnings and notices (e.g. recovered journals and automatic index creation)
+// in addition to errors. Passing nil discards all messages, which is the
+// default.
+//
+// The function may be called concurrently from any goroutine that is using
+// SQLite and it must not call any methods of this package. SetLogger may be
+// called at any time, but messages are only delivered if the log callback was
+// installed by Initialize, which runs when the library is first used and again
+// after each call to Shutdown. Installation fails if the system SQLite library
+// (libsqlite3 build tag) was already initialized by another user.
+// [http://www.sqlite.org/errlog.html]
+func SetLogger(f LogFunc) {
+	loggerMu.Lock()
+	defer loggerMu.Unlock()
+	logger = f
+}
+
+// loadLogger returns the current error log callback.
+func loadLogger() LogFunc {
+	loggerMu.RLock()
+	defer loggerMu.RUnlock()
+	return logger
+}
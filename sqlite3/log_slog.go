@@ -0,0 +1,32 @@
+// Copyright 2013 The Go-SQLite Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+//go:build go1.21
+
+package sqlite3
+
+import (
+	"context"
+	"log/slog"
+)
+
+// SlogLogger returns a LogFunc that writes SQLite error log messages to l.
+// NOTICE codes are logged at LevelInfo, WARNING codes at LevelWarn, and all
+// other codes at LevelError. The result code is added as the "code" attribute.
+// The default logger is used if l is nil.
+func SlogLogger(l *slog.Logger) LogFunc {
+	if l == nil {
+		l = slog.Default()
+	}
+	return func(code int, msg string) {
+		level := slog.LevelError
+		switch code & 0xff {
+		case NOTICE:
+			level = slog.LevelInfo
+		case WARNING:
+			level = slog.LevelWarn
+		}
+		l.Log(context.Background(), level, msg, slog.Int("code", code))
+	}
+}
@@ -0,0 +1,560 @@
+// Copyright 2013 The Go-SQLite Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+/*
+Package migrate applies versioned schema migrations to SQLite databases opened
+by the sqlite3 package.
+
+Each migration has a unique positive version number and a pair of SQL scripts:
+Up, which moves the schema from the previous version to this one, and Down,
+which reverses that change. Migrations are applied in version order, one per
+transaction, so a failed migration leaves the database at the last version that
+was applied successfully:
+
+	m, err := migrate.New(
+		&migrate.Migration{Version: 1, Up: "CREATE TABLE user(id, name)"},
+		&migrate.Migration{Version: 2, Up: "CREATE INDEX user_name ON user(name)",
+			Down: "DROP INDEX user_name"},
+	)
+	if err == nil {
+		err = m.Up(c)
+	}
+
+By default, the current version is stored in PRAGMA user_version. If Migrator
+Table is set, applied migrations are instead recorded in a table of that name
+along with a checksum of their Up scripts, which allows Verify to detect
+migrations that were modified after being applied.
+
+Foreign key enforcement is disabled while each migration runs, and PRAGMA
+foreign_key_check is executed before the transaction is committed. This allows
+migration functions to use Rebuild for schema changes that ALTER TABLE does not
+support:
+
+	&migrate.Migration{Version: 3, UpFunc: func(c *sqlite3.Conn) error {
+		return migrate.Rebuild(c, "user", "id INTEGER PRIMARY KEY, name TEXT", "")
+	}}
+*/
+package migrate
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"io/fs"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/mxk/go-sqlite/sqlite3"
+)
+
+// Migration is a single schema change.
+type Migration struct {
+	Version int    // Unique version number (> 0)
+	Name    string // Optional description
+	Up      string // SQL script that applies the change
+	Down    string // SQL script that reverts the change (optional)
+
+	// UpFunc and DownFunc, if set, are called after the Up and Down scripts,
+	// respectively, within the same transaction. They implement changes that
+	// cannot be expressed in SQL alone, such as calls to Rebuild. Functions
+	// are not covered by the checksum.
+	UpFunc   func(c *sqlite3.Conn) error
+	DownFunc func(c *sqlite3.Conn) error
+}
+
+// checksum returns the hex-encoded SHA-256 hash of the Up script.
+func (m *Migration) checksum() string {
+	h := sha256.Sum256([]byte(m.Up))
+	return hex.EncodeToString(h[:])
+}
+
+// String returns the version and name of m.
+func (m *Migration) String() string {
+	if m.Name == "" {
+		return strconv.Itoa(m.Version)
+	}
+	return strconv.Itoa(m.Version) + " (" + m.Name + ")"
+}
+
+// DriftError is returned when the Up script of an applied migration no longer
+// matches the checksum recorded in the migrations table.
+type DriftError struct {
+	Migration *Migration
+	Checksum  string // Checksum recorded when the migration was applied
+}
+
+// Error implements the error interface.
+func (err *DriftError) Error() string {
+	return fmt.Sprintf("migrate: migration %v was modified after being applied",
+		err.Migration)
+}
+
+// Migrator applies a fixed set of migrations to one or more databases.
+type Migrator struct {
+	// Table is the name of the table in the main database that records applied
+	// migrations. If empty, the current version is stored in user_version and
+	// checksums are not verified.
+	Table string
+
+	migs []*Migration
+}
+
+// New returns a Migrator for the specified migrations, which are sorted by
+// version. An error is returned if any version is not positive or is used more
+// than once.
+func New(ms ...*Migration) (*Migrator, error) {
+	migs := append([]*Migration(nil), ms...)
+	sort.Slice(migs, func(i, j int) bool { return migs[i].Version < migs[j].Version })
+	for i, m := range migs {
+		if m.Version <= 0 {
+			return nil, errorf(sqlite3.MISUSE, "invalid migration version (%d)",
+				m.Version)
+		}
+		if i > 0 && migs[i-1].Version == m.Version {
+			return nil, errorf(sqlite3.MISUSE, "duplicate migration version (%d)",
+				m.Version)
+		}
+	}
+	return &Migrator{migs: migs}, nil
+}
+
+// Load reads migrations from directory dir of fsys. File names must have the
+// format "<version>[_<name>].up.sql" or "<version>[_<name>].down.sql" (e.g.
+// "0001_create_users.up.sql"). Other files are ignored.
+func Load(fsys fs.FS, dir string) ([]*Migration, error) {
+	files, err := fs.ReadDir(fsys, dir)
+	if err != nil {
+		return nil, err
+	}
+	byVer := make(map[int]*Migration)
+	var migs []*Migration
+	for _, f := range files {
+		name := f.Name()
+		var up bool
+		switch {
+		case f.IsDir():
+			continue
+		case strings.HasSuffix(name, ".up.sql"):
+			name, up = strings.TrimSuffix(name, ".up.sql"), true
+		case strings.HasSuffix(name, ".down.sql"):
+			name = strings.TrimSuffix(name, ".down.sql")
+		default:
+			continue
+		}
+		ver, desc := name, ""
+		if i := strings.IndexByte(name, '_'); i >= 0 {
+			ver, desc = name[:i], name[i+1:]
+		}
+		v, err := strconv.Atoi(ver)
+		if err != nil || v <= 0 {
+			return nil, errorf(sqlite3.MISUSE, "invalid migration file name (%s)",
+				f.Name())
+		}
+		b, err := fs.ReadFile(fsys, path.Join(dir, f.Name()))
+		if err != nil {
+			return nil, err
+		}
+		m := byVer[v]
+		if m == nil {
+			m = &Migration{Version: v, Name: desc}
+			byVer[v] = m
+			migs = append(migs, m)
+		} else if m.Name != desc {
+			return nil, errorf(sqlite3.MISUSE, "migration %d has conflicting "+
+				"names (%q and %q)", v, m.Name, desc)
+		}
+		if up {
+			m.Up = string(b)
+		} else {
+			m.Down = string(b)
+		}
+	}
+	for _, m := range migs {
+		if m.Up == "" {
+			return nil, errorf(sqlite3.MISUSE, "migration %v has no up script", m)
+		}
+	}
+	sort.Slice(migs, func(i, j int) bool { return migs[i].Version < migs[j].Version })
+	return migs, nil
+}
+
+// Migrations returns all migrations in version order.
+func (m *Migrator) Migrations() []*Migration {
+	return append([]*Migration(nil), m.migs...)
+}
+
+// Latest returns the highest migration version, or 0 if there are no
+// migrations.
+func (m *Migrator) Latest() int {
+	if len(m.migs) == 0 {
+		return 0
+	}
+	return m.migs[len(m.migs)-1].Version
+}
+
+// Version returns the current schema version of the main database.
+func (m *Migrator) Version(c *sqlite3.Conn) (int, error) {
+	if m.Table == "" {
+		return queryInt(c, "PRAGMA main.user_version")
+	}
+	if ok, err := m.hasTable(c); !ok {
+		return 0, err
+	}
+	return queryInt(c, "SELECT ifnull(max(version),0) FROM main."+quote(m.Table))
+}
+
+// Verify checks that the migrations recorded in the database are consistent with
+// m. It returns a *DriftError if the Up script of an applied migration has been
+// modified, and an *sqlite3.Error if the database contains migrations that are
+// unknown to m or if some earlier migration was skipped. Only the version is
+// checked when the migrations table is not used.
+func (m *Migrator) Verify(c *sqlite3.Conn) error {
+	if m.Table == "" {
+		v, err := m.Version(c)
+		if err == nil && v != 0 && m.find(v) < 0 {
+			err = errorf(sqlite3.ERROR, "unknown database version (%d)", v)
+		}
+		return err
+	}
+	if ok, err := m.hasTable(c); !ok {
+		return err
+	}
+	s, err := c.Query("SELECT version, checksum FROM main." + quote(m.Table) +
+		" ORDER BY version")
+	applied := make(map[int]bool)
+	var ver int
+	var sum string
+	for ; err == nil; err = s.Next() {
+		if err = s.Scan(&ver, &sum); err != nil {
+			break
+		}
+		i := m.find(ver)
+		if i < 0 {
+			err = errorf(sqlite3.ERROR, "unknown migration version (%d)", ver)
+			break
+		}
+		if mig := m.migs[i]; mig.checksum() != sum {
+			err = &DriftError{mig, sum}
+			break
+		}
+		applied[ver] = true
+	}
+	if s != nil {
+		s.Close()
+	}
+	if err != io.EOF {
+		return err
+	}
+	for _, mig := range m.migs {
+		if mig.Version > ver {
+			break
+		}
+		if !applied[mig.Version] {
+			return errorf(sqlite3.ERROR, "migration %v was not applied before %d",
+				mig, ver)
+		}
+	}
+	return nil
+}
+
+// Up applies all pending migrations.
+func (m *Migrator) Up(c *sqlite3.Conn) error {
+	return m.To(c, m.Latest())
+}
+
+// Down reverts the most recently applied migration.
+func (m *Migrator) Down(c *sqlite3.Conn) error {
+	v, err := m.Version(c)
+	if err != nil || v == 0 {
+		return err
+	}
+	i := m.find(v)
+	if i < 0 {
+		return errorf(sqlite3.ERROR, "unknown database version (%d)", v)
+	}
+	if i == 0 {
+		return m.To(c, 0)
+	}
+	return m.To(c, m.migs[i-1].Version)
+}
+
+// To applies or reverts migrations until the database is at the specified
+// version, which must be 0 or the version of one of the migrations. The
+// database is verified before any changes are made. Each migration is executed
+// in a separate transaction, so c must be in auto-commit mode.
+func (m *Migrator) To(c *sqlite3.Conn, version int) error {
+	if version != 0 && m.find(version) < 0 {
+		return errorf(sqlite3.MISUSE, "unknown migration version (%d)", version)
+	}
+	if !c.AutoCommit() {
+		return errorf(sqlite3.MISUSE, "migrations cannot run within a transaction")
+	}
+	if err := m.Verify(c); err != nil {
+		return err
+	}
+	cur, err := m.Version(c)
+	if err != nil {
+		return err
+	}
+	if m.Table != "" {
+		err = c.Exec("CREATE TABLE IF NOT EXISTS main." + quote(m.Table) +
+			"(version INTEGER PRIMARY KEY, name TEXT NOT NULL, " +
+			"checksum TEXT NOT NULL, applied_at TEXT NOT NULL)")
+		if err != nil {
+			return err
+		}
+	}
+	for _, mig := range m.migs {
+		if cur < mig.Version && mig.Version <= version {
+			if err = m.apply(c, mig, true); err != nil {
+				return err
+			}
+		}
+	}
+	for i := len(m.migs) - 1; i >= 0; i-- {
+		if mig := m.migs[i]; version < mig.Version && mig.Version <= cur {
+			if err = m.apply(c, mig, false); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// apply executes the Up or Down script of mig and records the new version in a
+// single transaction.
+func (m *Migrator) apply(c *sqlite3.Conn, mig *Migration, up bool) error {
+	script, fn := mig.Up, mig.UpFunc
+	if !up {
+		if script, fn = mig.Down, mig.DownFunc; script == "" && fn == nil {
+			return errorf(sqlite3.MISUSE, "migration %v cannot be reverted", mig)
+		}
+	}
+	fk, err := queryInt(c, "PRAGMA foreign_keys")
+	if err != nil {
+		return err
+	}
+	if fk != 0 {
+		if err = c.Exec("PRAGMA foreign_keys=OFF"); err != nil {
+			return err
+		}
+		defer c.Exec("PRAGMA foreign_keys=ON")
+	}
+	if err = c.Exec("BEGIN IMMEDIATE"); err != nil {
+		return err
+	}
+	if err = m.run(c, mig, script, fn, up, fk != 0); err == nil {
+		err = c.Commit()
+	}
+	if err != nil {
+		if !c.AutoCommit() {
+			c.Rollback()
+		}
+		return fmt.Errorf("migrate: migration %v: %w", mig, err)
+	}
+	return nil
+}
+
+// run is the body of the transaction started by apply.
+func (m *Migrator) run(c *sqlite3.Conn, mig *Migration, script string,
+	fn func(*sqlite3.Conn) error, up, fkCheck bool) error {
+	if err := c.Exec(script); err != nil {
+		return err
+	}
+	if fn != nil {
+		if err := fn(c); err != nil {
+			return err
+		}
+	}
+	if fkCheck {
+		s, err := c.Query("PRAGMA foreign_key_check")
+		if err == nil {
+			var tbl string
+			s.Scan(&tbl)
+			s.Close()
+			return errorf(sqlite3.CONSTRAINT, "foreign key violation in table %q", tbl)
+		} else if err != io.EOF {
+			return err
+		}
+	}
+	if m.Table == "" {
+		v := 0
+		if !up {
+			if i := m.find(mig.Version); i > 0 {
+				v = m.migs[i-1].Version
+			}
+		} else {
+			v = mig.Version
+		}
+		return c.Exec("PRAGMA main.user_version=" + strconv.Itoa(v))
+	}
+	tbl := "main." + quote(m.Table)
+	if up {
+		return c.Exec("INSERT INTO "+tbl+" VALUES(?,?,?,datetime('now'))",
+			mig.Version, mig.Name, mig.checksum())
+	}
+	return c.Exec("DELETE FROM "+tbl+" WHERE version=?", mig.Version)
+}
+
+// find returns the index of the migration with the specified version or -1.
+func (m *Migrator) find(version int) int {
+	i := sort.Search(len(m.migs), func(i int) bool { return m.migs[i].Version >= version })
+	if i < len(m.migs) && m.migs[i].Version == version {
+		return i
+	}
+	return -1
+}
+
+// hasTable returns true if the migrations table exists.
+func (m *Migrator) hasTable(c *sqlite3.Conn) (bool, error) {
+	n, err := queryInt(c, "SELECT count(*) FROM main.sqlite_master "+
+		"WHERE type='table' AND name=?", m.Table)
+	return n > 0, err
+}
+
+// Rebuild changes the definition of table tbl in the main database using the
+// procedure described in the SQLite documentation for schema changes that ALTER
+// TABLE does not support. A new table is created with the column definitions
+// and constraints in def (the text between the parentheses of CREATE TABLE),
+// and populated by executing "INSERT INTO <new> " + sel. If sel is empty, the
+// values of all columns that exist in both tables are copied. The old table is
+// then dropped, the new one is renamed to tbl, and all indices and triggers that
+// were associated with the old table are recreated. Indices and triggers that
+// refer to removed columns must be dropped before calling Rebuild.
+//
+// Rebuild must be called within a transaction with foreign key enforcement
+// disabled, which is the case for Migration UpFunc and DownFunc calls.
+// [http://www.sqlite.org/lang_altertable.html#otheralter]
+func Rebuild(c *sqlite3.Conn, tbl, def, sel string) error {
+	if c.AutoCommit() {
+		return errorf(sqlite3.MISUSE, "table rebuild requires a transaction")
+	}
+	if fk, err := queryInt(c, "PRAGMA foreign_keys"); err != nil {
+		return err
+	} else if fk != 0 {
+		return errorf(sqlite3.MISUSE, "table rebuild requires foreign_keys=OFF")
+	}
+
+	// Save indices and triggers
+	var objs []string
+	s, err := c.Query("SELECT sql FROM main.sqlite_master WHERE tbl_name=? "+
+		"COLLATE NOCASE AND type IN ('index','trigger') AND sql NOT NULL "+
+		"ORDER BY rowid", tbl)
+	var sql string
+	for ; err == nil; err = s.Next() {
+		if err = s.Scan(&sql); err != nil {
+			break
+		}
+		objs = append(objs, sql)
+	}
+	if s != nil {
+		s.Close()
+	}
+	if err != io.EOF {
+		return err
+	}
+
+	// Create and populate the new table
+	tmp := quote("migrate_new_" + tbl)
+	if err = c.Exec("CREATE TABLE main." + tmp + "(" + def + ")"); err != nil {
+		return err
+	}
+	if sel == "" {
+		if sel, err = commonColumns(c, tbl, "migrate_new_"+tbl); err != nil {
+			return err
+		}
+	}
+	if sel != "" {
+		if err = c.Exec("INSERT INTO main." + tmp + " " + sel); err != nil {
+			return err
+		}
+	}
+
+	// Replace the old table and restore indices and triggers
+	err = c.Exec("DROP TABLE main." + quote(tbl) + ";" +
+		"ALTER TABLE main." + tmp + " RENAME TO " + quote(tbl))
+	for _, sql := range objs {
+		if err != nil {
+			break
+		}
+		err = c.Exec(sql)
+	}
+	return err
+}
+
+// commonColumns returns a SELECT statement that reads all columns of table src
+// that also exist in table dst, prefixed with the list of those columns.
+func commonColumns(c *sqlite3.Conn, src, dst string) (string, error) {
+	have, err := columns(c, src)
+	if err != nil {
+		return "", err
+	}
+	want, err := columns(c, dst)
+	if err != nil {
+		return "", err
+	}
+	var cols []string
+	for _, col := range want {
+		for _, h := range have {
+			if strings.EqualFold(col, h) {
+				cols = append(cols, quote(col))
+				break
+			}
+		}
+	}
+	if len(cols) == 0 {
+		return "", nil
+	}
+	list := strings.Join(cols, ",")
+	return "(" + list + ") SELECT " + list + " FROM main." + quote(src), nil
+}
+
+// columns returns the column names of table tbl in the main database.
+func columns(c *sqlite3.Conn, tbl string) ([]string, error) {
+	s, err := c.Query("PRAGMA main.table_info(" + quote(tbl) + ")")
+	var cols []string
+	var name string
+	for ; err == nil; err = s.Next() {
+		if err = s.Scan(nil, &name); err != nil {
+			break
+		}
+		cols = append(cols, name)
+	}
+	if s != nil {
+		s.Close()
+	}
+	if err != io.EOF {
+		return nil, err
+	}
+	return cols, nil
+}
+
+// queryInt returns the integer value of the first column of the first row
+// returned by sql. It returns 0 if there are no rows.
+func queryInt(c *sqlite3.Conn, sql string, args ...interface{}) (int, error) {
+	s, err := c.Query(sql, args...)
+	if err != nil {
+		if err == io.EOF {
+			err = nil
+		}
+		return 0, err
+	}
+	defer s.Close()
+	var n int
+	err = s.Scan(&n)
+	return n, err
+}
+
+// quote returns s as a quoted SQL identifier.
+func quote(s string) string {
+	return `"` + strings.Replace(s, `"`, `""`, -1) + `"`
+}
+
+// errorf returns a new *sqlite3.Error with a formatted message.
+func errorf(rc int, format string, v ...interface{}) error {
+	return sqlite3.NewError(rc, "migrate: "+fmt.Sprintf(format, v...))
+}
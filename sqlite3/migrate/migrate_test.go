@@ -0,0 +1,218 @@
+// Copyright 2013 The Go-SQLite Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package migrate
+
+import (
+	"errors"
+	"io"
+	"reflect"
+	"testing"
+	"testing/fstest"
+
+	"github.com/mxk/go-sqlite/sqlite3"
+)
+
+func open(t *testing.T) *sqlite3.Conn {
+	c, err := sqlite3.Open(":memory:")
+	if err != nil {
+		t.Fatalf("Open() unexpected error: %v", err)
+	}
+	return c
+}
+
+func count(t *testing.T, c *sqlite3.Conn, sql string) int {
+	n, err := queryInt(c, sql)
+	if err != nil {
+		t.Fatalf("queryInt(%q) unexpected error: %v", sql, err)
+	}
+	return n
+}
+
+func testMigrations() []*Migration {
+	return []*Migration{{
+		Version: 10,
+		Name:    "user",
+		Up:      "CREATE TABLE user(id INTEGER PRIMARY KEY, name TEXT, age INT)",
+		Down:    "DROP TABLE user",
+	}, {
+		Version: 20,
+		Name:    "post",
+		Up: "CREATE TABLE post(id INTEGER PRIMARY KEY, uid REFERENCES user(id));" +
+			"CREATE INDEX post_uid ON post(uid)",
+		Down: "DROP TABLE post",
+	}, {
+		Version: 30,
+		Name:    "drop_age",
+		Up:      "SELECT 1",
+	}}
+}
+
+func TestLoad(t *testing.T) {
+	fsys := fstest.MapFS{
+		"db/0002_b.up.sql":   {Data: []byte("B")},
+		"db/0002_b.down.sql": {Data: []byte("-B")},
+		"db/1.up.sql":        {Data: []byte("A")},
+		"db/README":          {Data: []byte("ignored")},
+	}
+	have, err := Load(fsys, "db")
+	if err != nil {
+		t.Fatalf("Load() unexpected error: %v", err)
+	}
+	want := []*Migration{
+		{Version: 1, Up: "A"},
+		{Version: 2, Name: "b", Up: "B", Down: "-B"},
+	}
+	if !reflect.DeepEqual(have, want) {
+		t.Fatalf("Load() expected %v; got %v", want, have)
+	}
+
+	fsys["db/3_c.down.sql"] = &fstest.MapFile{Data: []byte("-C")}
+	if _, err = Load(fsys, "db"); err == nil {
+		t.Fatalf("Load() expected an error for missing up script")
+	}
+	if _, err = New(want[0], want[0]); err == nil {
+		t.Fatalf("New() expected an error for duplicate versions")
+	}
+}
+
+func TestMigrate(t *testing.T) {
+	for _, table := range []string{"", "schema_migrations"} {
+		c := open(t)
+		m, err := New(testMigrations()...)
+		if err != nil {
+			t.Fatalf("New() unexpected error: %v", err)
+		}
+		m.Table = table
+		c.Exec("PRAGMA foreign_keys=ON")
+
+		if err = m.To(c, 20); err != nil {
+			t.Fatalf("m.To(20) unexpected error: %v", err)
+		}
+		if v, _ := m.Version(c); v != 20 {
+			t.Fatalf("m.Version() expected 20; got %d", v)
+		}
+		c.Exec("INSERT INTO user VALUES(1, 'alice', 30); INSERT INTO post VALUES(1, 1)")
+
+		if err = m.Down(c); err != nil {
+			t.Fatalf("m.Down() unexpected error: %v", err)
+		}
+		if v, _ := m.Version(c); v != 10 {
+			t.Fatalf("m.Version() expected 10; got %d", v)
+		}
+		if err = m.Up(c); err != nil {
+			t.Fatalf("m.Up() unexpected error: %v", err)
+		}
+		if v, _ := m.Version(c); v != 30 {
+			t.Fatalf("m.Version() expected 30; got %d", v)
+		}
+		if err = m.To(c, 0); err == nil {
+			t.Fatalf("m.To(0) expected an error for irreversible migration")
+		}
+		if v, _ := m.Version(c); v != 30 {
+			t.Fatalf("m.Version() expected 30; got %d", v)
+		}
+		if count(t, c, "PRAGMA foreign_keys") != 1 {
+			t.Fatalf("foreign_keys was not restored")
+		}
+
+		// Failed migrations are rolled back
+		bad := append(testMigrations(), &Migration{Version: 40,
+			Up: "CREATE TABLE x(a); INSERT INTO post VALUES(2, 99)"})
+		m2, _ := New(bad...)
+		m2.Table = table
+		if err = m2.Up(c); err == nil {
+			t.Fatalf("m2.Up() expected a foreign key error")
+		} else if e, ok := errors.Unwrap(err).(*sqlite3.Error); !ok ||
+			e.Code() != sqlite3.CONSTRAINT {
+			t.Fatalf("m2.Up() expected CONSTRAINT error; got %v", err)
+		}
+		if v, _ := m2.Version(c); v != 30 {
+			t.Fatalf("m2.Version() expected 30; got %d", v)
+		}
+		if count(t, c, "SELECT count(*) FROM sqlite_master WHERE name='x'") != 0 {
+			t.Fatalf("failed migration was not rolled back")
+		}
+
+		// Unknown versions
+		m3, _ := New(testMigrations()[:2]...)
+		m3.Table = table
+		if err = m3.Verify(c); err == nil {
+			t.Fatalf("m3.Verify() expected an error for unknown version")
+		}
+		c.Close()
+	}
+}
+
+func TestDrift(t *testing.T) {
+	c := open(t)
+	defer c.Close()
+	migs := testMigrations()
+	m, _ := New(migs...)
+	m.Table = "migrations"
+	if err := m.Up(c); err != nil {
+		t.Fatalf("m.Up() unexpected error: %v", err)
+	}
+	if err := m.Verify(c); err != nil {
+		t.Fatalf("m.Verify() unexpected error: %v", err)
+	}
+	migs[1].Up += ";"
+	err := m.Verify(c)
+	if e, ok := err.(*DriftError); !ok || e.Migration != migs[1] {
+		t.Fatalf("m.Verify() expected DriftError; got %v", err)
+	}
+	if err = m.Down(c); err == nil {
+		t.Fatalf("m.Down() expected DriftError")
+	}
+}
+
+func TestRebuild(t *testing.T) {
+	c := open(t)
+	defer c.Close()
+	rebuild := func(c *sqlite3.Conn) error {
+		return Rebuild(c, "user", "id INTEGER PRIMARY KEY, name TEXT NOT NULL", "")
+	}
+	m, _ := New(append(testMigrations()[:2], &Migration{
+		Version: 30,
+		Up: `CREATE TRIGGER user_del AFTER DELETE ON user BEGIN
+			DELETE FROM post WHERE uid=old.id; END;
+			CREATE INDEX user_name ON user(name);
+			INSERT INTO user VALUES(1, 'alice', 30);
+			INSERT INTO post VALUES(1, 1);`,
+	}, &Migration{
+		Version: 40,
+		Name:    "drop_age",
+		UpFunc:  rebuild,
+	})...)
+	c.Exec("PRAGMA foreign_keys=ON")
+	if err := m.To(c, 30); err != nil {
+		t.Fatalf("m.To(30) unexpected error: %v", err)
+	}
+	c.Exec("BEGIN")
+	if err := rebuild(c); err == nil {
+		t.Fatalf("Rebuild() expected an error with foreign_keys=ON")
+	}
+	c.Exec("ROLLBACK")
+	if err := m.Up(c); err != nil {
+		t.Fatalf("m.Up() unexpected error: %v", err)
+	}
+	if n := count(t, c, "SELECT count(*) FROM sqlite_master WHERE "+
+		"tbl_name='user' AND name IN ('user','user_del','user_name')"); n != 3 {
+		t.Fatalf("expected 3 schema objects; got %d", n)
+	}
+	cols, _ := columns(c, "user")
+	if !reflect.DeepEqual(cols, []string{"id", "name"}) {
+		t.Fatalf("columns() expected [id name]; got %v", cols)
+	}
+	if n := count(t, c, "SELECT count(*) FROM user WHERE name='alice'"); n != 1 {
+		t.Fatalf("user data was not copied")
+	}
+	c.Exec("DELETE FROM user")
+	if n := count(t, c, "SELECT count(*) FROM post"); n != 0 {
+		t.Fatalf("trigger was not restored")
+	}
+	if _, err := c.Query("PRAGMA foreign_key_check"); err != io.EOF {
+		t.Fatalf("foreign_key_check expected io.EOF; got %v", err)
+	}
+}
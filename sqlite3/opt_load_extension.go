@@ -0,0 +1,13 @@
+// Copyright 2013 The Go-SQLite Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+//go:build sqlite_load_extension && !libsqlite3
+
+package sqlite3
+
+/*
+// Loadable extensions. Enabled by the sqlite_load_extension tag.
+#cgo linux LDFLAGS: -ldl
+*/
+import "C"
@@ -0,0 +1,14 @@
+// Copyright 2013 The Go-SQLite Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+//go:build !sqlite_load_extension && !libsqlite3
+
+package sqlite3
+
+/*
+// Loadable extensions are omitted from the bundled amalgamation unless the
+// sqlite_load_extension tag is specified.
+#cgo CFLAGS: -DSQLITE_OMIT_LOAD_EXTENSION=1
+*/
+import "C"
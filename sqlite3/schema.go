@@ -0,0 +1,295 @@
+// Copyright 2013 The Go-SQLite Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package sqlite3
+
+/*
+#include "sqlite3.h"
+*/
+import "C"
+
+import (
+	"io"
+	"strings"
+)
+
+// Schema describes all objects in one database, in the order in which they
+// were created.
+type Schema struct {
+	Tables   []*Table
+	Views    []*View
+	Indexes  []*Index
+	Triggers []*Trigger
+}
+
+// Table describes a table. Internal tables (e.g. sqlite_sequence) are included.
+type Table struct {
+	Name          string
+	SQL           string // CREATE TABLE statement
+	Virtual       bool   // Virtual table (Columns may be incomplete)
+	AutoIncrement bool   // Uses the AUTOINCREMENT keyword
+	PrimaryKey    []string
+	Columns       []*Column
+	Indexes       []*Index
+	ForeignKeys   []*ForeignKey
+	Triggers      []*Trigger
+	Err           error // Error that prevented columns or foreign keys from being read
+}
+
+// Column returns the column with the specified name, using case-insensitive
+// comparison, or nil if there is no such column.
+func (t *Table) Column(name string) *Column {
+	for _, c := range t.Columns {
+		if strings.EqualFold(c.Name, name) {
+			return c
+		}
+	}
+	return nil
+}
+
+// Column describes a table or view column.
+type Column struct {
+	Name          string
+	Type          string // Declared type
+	Collation     string // Collating sequence name (tables only)
+	Default       string // Default value expression or "" if there is none
+	NotNull       bool
+	PrimaryKey    int  // Position in the primary key (starting at 1) or 0
+	AutoIncrement bool // INTEGER PRIMARY KEY AUTOINCREMENT column
+}
+
+// Index describes an index. Automatic indices, which are created for UNIQUE and
+// PRIMARY KEY constraints, have an empty SQL field.
+type Index struct {
+	Name    string
+	Table   string
+	SQL     string // CREATE INDEX statement
+	Unique  bool
+	Columns []string
+}
+
+// ForeignKey describes a foreign key constraint. The To slice contains empty
+// strings if the constraint refers to the primary key of the parent table
+// without naming the columns.
+type ForeignKey struct {
+	Table    string   // Parent table
+	From     []string // Child key columns
+	To       []string // Parent key columns
+	OnUpdate string
+	OnDelete string
+	Match    string
+
+	id int // Constraint ID reported by PRAGMA foreign_key_list
+}
+
+// Trigger describes a trigger.
+type Trigger struct {
+	Name  string
+	Table string
+	SQL   string
+}
+
+// View describes a view.
+type View struct {
+	Name    string
+	SQL     string
+	Columns []*Column
+	Err     error // Error that prevented columns from being read
+}
+
+// Schema returns a description of all objects in database db (e.g. "main").
+// The information is obtained from sqlite_master, PRAGMA table_info,
+// index_list, index_info, and foreign_key_list, and from
+// sqlite3_table_column_metadata. If the columns of a table or view cannot be
+// read (e.g. a virtual table whose module is not registered or a view that
+// refers to a dropped table), the error is stored in its Err field and the
+// remaining objects are still described.
+// [http://www.sqlite.org/pragma.html#pragma_table_info]
+// [http://www.sqlite.org/c3ref/table_column_metadata.html]
+func (c *Conn) Schema(db string) (*Schema, error) {
+	if c.db == nil {
+		return nil, ErrBadConn
+	}
+	db = dbName(db)
+	qdb := quoteIdent(db)
+	s, err := c.Query("SELECT type, name, tbl_name, ifnull(sql,'') FROM " + qdb +
+		".sqlite_master ORDER BY rowid")
+	sc := new(Schema)
+	tables := make(map[string]*Table)
+	var typ, name, tbl, sql string
+	for ; err == nil; err = s.Next() {
+		if err = s.Scan(&typ, &name, &tbl, &sql); err != nil {
+			break
+		}
+		switch typ {
+		case "table":
+			t := &Table{Name: name, SQL: sql}
+			t.Virtual = strings.HasPrefix(strings.ToUpper(sql), "CREATE VIRTUAL")
+			sc.Tables = append(sc.Tables, t)
+			tables[strings.ToLower(name)] = t
+		case "view":
+			sc.Views = append(sc.Views, &View{Name: name, SQL: sql})
+		case "index":
+			sc.Indexes = append(sc.Indexes, &Index{Name: name, Table: tbl, SQL: sql})
+		case "trigger":
+			sc.Triggers = append(sc.Triggers, &Trigger{Name: name, Table: tbl, SQL: sql})
+		}
+	}
+	if s != nil {
+		s.Close()
+	}
+	if err != io.EOF {
+		return nil, err
+	}
+
+	for _, t := range sc.Tables {
+		if t.Columns, t.Err = c.columns(db, t.Name, !t.Virtual); t.Err != nil {
+			continue
+		}
+		for _, col := range t.Columns {
+			if col.PrimaryKey > 0 {
+				t.PrimaryKey = append(t.PrimaryKey, "")
+			}
+			t.AutoIncrement = t.AutoIncrement || col.AutoIncrement
+		}
+		for _, col := range t.Columns {
+			if col.PrimaryKey > 0 && col.PrimaryKey <= len(t.PrimaryKey) {
+				t.PrimaryKey[col.PrimaryKey-1] = col.Name
+			}
+		}
+		t.ForeignKeys, t.Err = c.foreignKeys(qdb, t.Name)
+	}
+	for _, v := range sc.Views {
+		v.Columns, v.Err = c.columns(db, v.Name, false)
+	}
+	for _, idx := range sc.Indexes {
+		if err = c.indexInfo(qdb, idx); err != nil {
+			return nil, err
+		}
+		if t := tables[strings.ToLower(idx.Table)]; t != nil {
+			t.Indexes = append(t.Indexes, idx)
+		}
+	}
+	for _, tr := range sc.Triggers {
+		if t := tables[strings.ToLower(tr.Table)]; t != nil {
+			t.Triggers = append(t.Triggers, tr)
+		}
+	}
+	return sc, nil
+}
+
+// columns returns the columns of table or view tbl. The collation and
+// autoincrement information is only available for tables, in which case meta
+// should be true.
+func (c *Conn) columns(db, tbl string, meta bool) ([]*Column, error) {
+	s, err := c.Query("PRAGMA " + quoteIdent(db) + ".table_info(" +
+		quoteIdent(tbl) + ")")
+	var cols []*Column
+	for ; err == nil; err = s.Next() {
+		col := new(Column)
+		err = s.Scan(nil, &col.Name, &col.Type, &col.NotNull, &col.Default,
+			&col.PrimaryKey)
+		if err != nil {
+			break
+		}
+		cols = append(cols, col)
+	}
+	if s != nil {
+		s.Close()
+	}
+	if err != io.EOF {
+		return nil, err
+	}
+	if meta {
+		for _, col := range cols {
+			if err = c.columnMetadata(db, tbl, col); err != nil {
+				return nil, err
+			}
+		}
+	}
+	return cols, nil
+}
+
+// columnMetadata sets the collation and autoincrement fields of col.
+func (c *Conn) columnMetadata(db, tbl string, col *Column) error {
+	db += "\x00"
+	tbl += "\x00"
+	name := col.Name + "\x00"
+	var coll *C.char
+	var notNull, pk, autoinc C.int
+	rc := C.sqlite3_table_column_metadata(c.db, cStr(db), cStr(tbl), cStr(name),
+		nil, &coll, &notNull, &pk, &autoinc)
+	if rc != OK {
+		return libErr(rc, c.db)
+	}
+	col.Collation = C.GoString(coll)
+	col.AutoIncrement = autoinc != 0
+	return nil
+}
+
+// foreignKeys returns the foreign key constraints of table tbl.
+func (c *Conn) foreignKeys(qdb, tbl string) ([]*ForeignKey, error) {
+	s, err := c.Query("PRAGMA " + qdb + ".foreign_key_list(" + quoteIdent(tbl) + ")")
+	var fks []*ForeignKey
+	var fk *ForeignKey
+	var id, prev int
+	var from, to string
+	for ; err == nil; err = s.Next() {
+		f := new(ForeignKey)
+		err = s.Scan(&id, nil, &f.Table, &from, &to, &f.OnUpdate, &f.OnDelete,
+			&f.Match)
+		if err != nil {
+			break
+		}
+		if fk == nil || id != prev {
+			fk, f.id = f, id
+			fks = append(fks, fk)
+		}
+		fk.From = append(fk.From, from)
+		fk.To = append(fk.To, to)
+		prev = id
+	}
+	if s != nil {
+		s.Close()
+	}
+	if err != io.EOF {
+		return nil, err
+	}
+	return fks, nil
+}
+
+// indexInfo sets the unique and columns fields of idx.
+func (c *Conn) indexInfo(qdb string, idx *Index) error {
+	s, err := c.Query("PRAGMA " + qdb + ".index_list(" + quoteIdent(idx.Table) + ")")
+	var name string
+	var unique bool
+	for ; err == nil; err = s.Next() {
+		if err = s.Scan(nil, &name, &unique); err != nil {
+			break
+		}
+		if strings.EqualFold(name, idx.Name) {
+			idx.Unique = unique
+		}
+	}
+	if s != nil {
+		s.Close()
+	}
+	if err != io.EOF {
+		return err
+	}
+	s, err = c.Query("PRAGMA " + qdb + ".index_info(" + quoteIdent(idx.Name) + ")")
+	for ; err == nil; err = s.Next() {
+		if err = s.Scan(nil, nil, &name); err != nil {
+			break
+		}
+		idx.Columns = append(idx.Columns, name)
+	}
+	if s != nil {
+		s.Close()
+	}
+	if err != io.EOF {
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,137 @@
+// Copyright 2013 The Go-SQLite Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package sqlite3
+
+/*
+#include "sqlite3.h"
+*/
+import "C"
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"sync/atomic"
+	"unsafe"
+)
+
+// memVFS is the name of the in-memory VFS implemented by lib/memvfs.c.
+const memVFS = C.MEMVFS_NAME
+
+// memFileId is used to generate unique in-memory file names.
+var memFileId uint64
+
+// memFile returns a new unique in-memory file name.
+func memFile() string {
+	return fmt.Sprintf("/go-sqlite-%d.db", atomic.AddUint64(&memFileId, 1))
+}
+
+// Serialize returns the contents of database db (e.g. "main") as it would be
+// stored on disk by a connection without a codec. If db has a codec attached,
+// the returned pages are decoded, but the number of bytes reserved at the end
+// of each page by the codec is preserved. The result can be passed to
+// Deserialize or written to a file and opened as a plaintext database. The
+// contents are obtained with the online backup API, so a consistent snapshot is
+// returned even if the database is modified by other connections.
+func (c *Conn) Serialize(db string) ([]byte, error) {
+	if c.db == nil {
+		return nil, ErrBadConn
+	}
+	name := memFile()
+	mc, err := open(name, memVFS)
+	if err != nil {
+		return nil, err
+	}
+	defer mc.Close()
+	opts := &BackupOptions{SrcName: db}
+	if err = c.BackupTo(context.Background(), mc, opts); err != nil {
+		return nil, err
+	}
+	name += "\x00"
+	var p unsafe.Pointer
+	var n C.sqlite3_int64
+	if rc := C.memvfs_data(cStr(name), &p, &n); rc != OK {
+		return nil, libErr(rc, nil)
+	}
+	if n > 1<<31-1 {
+		return nil, pkgErr(TOOBIG, "serialized database is too large")
+	}
+	return C.GoBytes(p, C.int(n)), nil
+}
+
+// Deserialize replaces the contents of database db with data, which must be a
+// database image returned by Serialize or read from a plaintext database file.
+// The main and temp databases are overwritten using the online backup API. If
+// the main database has a codec attached, the reserve value in the image must
+// match that of the codec (see Conn.Backup). These databases cannot be made
+// read-only. Any other name causes data to be attached as a new in-memory
+// database, replacing an existing attached database with the same name. The
+// attached database does not use a codec and it is read-only if readOnly is
+// true. Its contents are discarded when it is detached or when the connection
+// is closed.
+func (c *Conn) Deserialize(db string, data []byte, readOnly bool) error {
+	if c.db == nil {
+		return ErrBadConn
+	}
+	name := memFile()
+	zName := name + "\x00"
+	var p unsafe.Pointer
+	if len(data) > 0 {
+		p = cBytes(data)
+	}
+	if rc := C.memvfs_put(cStr(zName), p, C.sqlite3_int64(len(data))); rc != OK {
+		return libErr(rc, nil)
+	}
+	defer C.memvfs_release(cStr(zName))
+
+	if db = dbName(db); db == "main" || db == "temp" {
+		if readOnly {
+			return pkgErr(MISUSE, "%s database cannot be made read-only", db)
+		}
+		mc, err := open(name, memVFS)
+		if err != nil {
+			return err
+		}
+		defer mc.Close()
+		opts := &BackupOptions{DstName: db}
+		return mc.BackupTo(context.Background(), c, opts)
+	}
+
+	attached, err := c.attached(db)
+	if err != nil {
+		return err
+	}
+	if attached {
+		if err = c.Exec("DETACH " + quoteIdent(db)); err != nil {
+			return err
+		}
+	}
+	uri := "file:" + name + "?vfs=" + memVFS
+	if readOnly {
+		uri += "&mode=ro"
+	}
+	// An empty key prevents db from using the key of the main database
+	return c.Exec("ATTACH ? AS "+quoteIdent(db)+" KEY ''", uri)
+}
+
+// attached returns true if a database with the specified name is attached to
+// the connection.
+func (c *Conn) attached(db string) (bool, error) {
+	s, err := c.Query("PRAGMA database_list")
+	if err != nil {
+		return false, err
+	}
+	defer s.Close()
+	var name string
+	for ; err == nil; err = s.Next() {
+		if err = s.Scan(nil, &name); err == nil && name == db {
+			return true, nil
+		}
+	}
+	if err == io.EOF {
+		err = nil
+	}
+	return false, err
+}
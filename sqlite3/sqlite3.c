@@ -2,5 +2,10 @@
 // Use of this source code is governed by a BSD-style
 // license that can be found in the LICENSE file.
 
+#ifdef USE_LIBSQLITE3
+#include "sqlite3.h"
+#else
 #include "lib/sqlite3.c"
+#endif
 #include "lib/codec.c"
+#include "lib/memvfs.c"
@@ -15,6 +15,7 @@ package sqlite3
 #cgo CFLAGS: -DSQLITE_THREADSAFE=2
 #cgo CFLAGS: -DSQLITE_TEMP_STORE=2
 #cgo CFLAGS: -DSQLITE_USE_URI=1
+#cgo CFLAGS: -DSQLITE_ENABLE_COLUMN_METADATA=1
 #cgo CFLAGS: -DSQLITE_ENABLE_FTS3_PARENTHESIS=1
 #cgo CFLAGS: -DSQLITE_ENABLE_FTS4=1
 #cgo CFLAGS: -DSQLITE_ENABLE_RTREE=1
@@ -22,10 +23,8 @@ package sqlite3
 #cgo CFLAGS: -DSQLITE_SOUNDEX=1
 #cgo CFLAGS: -DSQLITE_OMIT_AUTHORIZATION=1
 #cgo CFLAGS: -DSQLITE_OMIT_AUTOINIT=1
-#cgo CFLAGS: -DSQLITE_OMIT_LOAD_EXTENSION=1
 #cgo CFLAGS: -DSQLITE_OMIT_TRACE=1
 #cgo CFLAGS: -DSQLITE_OMIT_UTF16=1
-#cgo CFLAGS: -DSQLITE_HAS_CODEC=1
 
 // Fix for BusyTimeout on *nix systems.
 #cgo !windows CFLAGS: -DHAVE_USLEEP=1
@@ -37,7 +36,9 @@ package sqlite3
 
 // cgo doesn't handle variadic functions.
 static void set_temp_dir(const char *path) {
-	sqlite3_temp_directory = sqlite3_mprintf("%s", path);
+	if (!sqlite3_temp_directory) {
+		sqlite3_temp_directory = sqlite3_mprintf("%s", path);
+	}
 }
 
 // cgo doesn't handle SQLITE_{STATIC,TRANSIENT} pointer constants.
@@ -92,41 +93,111 @@ int go_busy_handler(void*,int);
 int go_commit_hook(void*);
 void go_rollback_hook(void*);
 void go_update_hook(void*,int,const char*,const char*,sqlite3_int64);
+void go_log(void*,int,const char*);
 
 SET(busy_handler)
 SET(commit_hook)
 SET(rollback_hook)
 SET(update_hook)
+
+// cgo doesn't handle variadic functions.
+static int config_log(void) {
+	return sqlite3_config(SQLITE_CONFIG_LOG, go_log, 0);
+}
 */
 import "C"
 
 import (
+	"encoding/json"
 	"io"
 	"os"
 	"runtime"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"time"
 	"unsafe"
 )
 
-// initErr indicates a SQLite initialization error, which disables this package.
-var initErr error
+// Initialization state, protected by initMu.
+var (
+	initMu    sync.Mutex
+	initDone  bool // SQLite is initialized
+	openConns int  // Number of connections that have not been closed
+)
 
 func init() {
+	// Register database/sql driver.
+	register("sqlite3")
+}
+
+// Initialize initializes the SQLite library. It is called automatically when
+// the first connection is opened and by all other functions that require an
+// initialized library, so an explicit call is only needed to detect errors
+// early. Global options must be changed with Config before initialization.
+// Additional calls have no effect until Shutdown is called.
+// [http://www.sqlite.org/c3ref/initialize.html]
+func Initialize() error {
+	initMu.Lock()
+	defer initMu.Unlock()
+	return initialize()
+}
+
+// initialize initializes SQLite and the package-specific global state if this
+// was not already done. The caller must hold initMu.
+func initialize() error {
+	if initDone {
+		return nil
+	}
+
+	// Install the error log callback, which must be done before initialization
+	// (see SetLogger). MISUSE is returned if the library is already initialized
+	// by another user of the shared library, in which case nothing is logged.
+	// [http://www.sqlite.org/errlog.html]
+	C.config_log()
+
 	// Initialize SQLite (required with SQLITE_OMIT_AUTOINIT).
-	// [http://www.sqlite.org/c3ref/initialize.html]
 	if rc := C.sqlite3_initialize(); rc != OK {
-		initErr = libErr(rc, nil)
-		return
+		return libErr(rc, nil)
+	}
+
+	// Register the in-memory VFS used by Serialize and Deserialize.
+	if rc := C.memvfs_register(); rc != OK {
+		return libErr(rc, nil)
 	}
 
 	// Use the same temporary directory as Go.
 	// [http://www.sqlite.org/c3ref/temp_directory.html]
 	tmp := os.TempDir() + "\x00"
 	C.set_temp_dir(cStr(tmp))
+	initDone = true
+	return nil
+}
 
-	// Register database/sql driver.
-	register("sqlite3")
+// Shutdown deallocates all resources allocated by Initialize, after which
+// Config may be used to change global options. The library is initialized
+// again when it is needed. A BUSY error is returned if any connections are
+// still open, including those left in the "zombie" state by Conn.Close until
+// their remaining statements, BLOB handles, and backups are closed. Extensions
+// registered by AutoExtension are unregistered. When the package is linked with
+// the system SQLite library, the caller must ensure that it is not being used by
+// anything else in the process.
+// [http://www.sqlite.org/c3ref/initialize.html]
+func Shutdown() error {
+	initMu.Lock()
+	defer initMu.Unlock()
+	if !initDone {
+		return nil
+	}
+	if openConns > 0 {
+		return pkgErr(BUSY, "cannot shut down with %d open connection(s)",
+			openConns)
+	}
+	if rc := C.sqlite3_shutdown(); rc != OK {
+		return libErr(rc, nil)
+	}
+	initDone = false
+	return nil
 }
 
 // Conn is a connection handle, which may have multiple databases attached to it
@@ -140,6 +211,13 @@ type Conn struct {
 	commit   CommitFunc
 	rollback RollbackFunc
 	update   UpdateFunc
+
+	instr Instrument // Statement instrumentation (see instrument.go)
+
+	// Number of statements, BLOB handles, and backups (as the source) that
+	// keep a "zombie" connection open after Close, plus one for the Conn itself
+	// until it is closed. Accessed atomically.
+	refs int32
 }
 
 // Open creates a new connection to a SQLite database. The name can be 1) a path
@@ -147,23 +225,48 @@ type Conn struct {
 // described at http://www.sqlite.org/uri.html, 3) the string ":memory:", which
 // creates a temporary in-memory database, or 4) an empty string, which creates
 // a temporary on-disk database (deleted when closed) in the directory returned
-// by os.TempDir().
+// by os.TempDir(). All functions registered by RegisterConnectHook are called
+// before the connection is returned.
 // [http://www.sqlite.org/c3ref/open.html]
 func Open(name string) (*Conn, error) {
-	if initErr != nil {
-		return nil, initErr
+	c, err := open(name, "")
+	if err == nil {
+		if err = c.connect(nil); err != nil {
+			return nil, err
+		}
+	}
+	return c, err
+}
+
+// open creates a new connection to database name using the specified VFS. The
+// default VFS is used if vfs is an empty string.
+func open(name, vfs string) (*Conn, error) {
+	initMu.Lock()
+	err := initialize()
+	if err == nil {
+		openConns++
+	}
+	initMu.Unlock()
+	if err != nil {
+		return nil, err
 	}
 	name += "\x00"
+	var zVfs *C.char
+	if vfs != "" {
+		vfs += "\x00"
+		zVfs = cStr(vfs)
+	}
 
 	var db *C.sqlite3
 	rc := C.sqlite3_open_v2(cStr(name), &db,
-		C.SQLITE_OPEN_READWRITE|C.SQLITE_OPEN_CREATE, nil)
+		C.SQLITE_OPEN_READWRITE|C.SQLITE_OPEN_CREATE, zVfs)
 	if rc != OK {
 		err := libErr(rc, db)
 		C.sqlite3_close(db)
+		connClosed()
 		return nil, err
 	}
-	c := &Conn{db: db}
+	c := &Conn{db: db, refs: 1}
 	C.sqlite3_extended_result_codes(db, 1)
 	runtime.SetFinalizer(c, (*Conn).Close)
 	return c, nil
@@ -185,14 +288,38 @@ func (c *Conn) Close() error {
 			err := libErr(rc, db)
 			if rc == BUSY {
 				C.sqlite3_close_v2(db)
+				c.unref() // openConns is decremented by the last unref
 			}
 			return err
 		}
 		*c = Conn{} // Clear callback handlers only if db was closed
+		connClosed()
 	}
 	return nil
 }
 
+// connClosed decrements the number of open connections.
+func connClosed() {
+	initMu.Lock()
+	openConns--
+	initMu.Unlock()
+}
+
+// ref records the creation of an object that prevents the connection from
+// being closed.
+func (c *Conn) ref() {
+	atomic.AddInt32(&c.refs, 1)
+}
+
+// unref records the release of an object created after a call to ref, or of
+// the Conn itself by Close. SQLite closes a zombie connection when the last
+// such object is released, so it is no longer counted as open.
+func (c *Conn) unref() {
+	if atomic.AddInt32(&c.refs, -1) == 0 {
+		connClosed()
+	}
+}
+
 // Prepare compiles the first statement in sql. Any remaining text after the
 // first statement is saved in Stmt.Tail.
 // [http://www.sqlite.org/c3ref/prepare.html]
@@ -220,7 +347,7 @@ func (c *Conn) Prepare(sql string) (*Stmt, error) {
 // 	c.Exec("UPDATE x SET a=$a; UPDATE x SET b=$b", args)
 //
 // Without any extra arguments, the statements in sql are executed by a single
-// call to sqlite3_exec.
+// call to sqlite3_exec, unless the connection has an Instrument.
 // [http://www.sqlite.org/c3ref/exec.html]
 func (c *Conn) Exec(sql string, args ...interface{}) error {
 	if c.db == nil {
@@ -228,9 +355,10 @@ func (c *Conn) Exec(sql string, args ...interface{}) error {
 	}
 
 	// Fast path via sqlite3_exec, which doesn't support parameter binding
-	if len(args) == 0 {
-		sql += "\x00"
-		return c.exec(cStr(sql))
+	bare := len(args) == 0
+	if bare && c.instr == nil {
+		zSql := sql + "\x00"
+		return sqlErr(c.exec(cStr(zSql)), sql, nil)
 	}
 
 	// Slow path via Prepare -> Exec -> Close
@@ -248,6 +376,9 @@ func (c *Conn) Exec(sql string, args ...interface{}) error {
 		}
 		if s.nVars == 0 {
 			return s.exec(nil)
+		} else if bare {
+			// Same as sqlite3_exec, which leaves all parameters as NULL
+			return s.exec(make([]interface{}, s.nVars))
 		}
 		sArgs := args
 		if unnamed {
@@ -469,22 +600,45 @@ func (c *Conn) UpdateFunc(f UpdateFunc) (prev UpdateFunc) {
 }
 
 // Key provides a codec key to an attached database. This method should be
-// called right after opening the connection.
+// called right after opening the connection. If the database file is not empty,
+// the key is verified by reading the database schema, and a NOTADB error is
+// returned if page 1 cannot be decoded. The database remains unusable until a
+// valid key is provided.
 func (c *Conn) Key(db string, key []byte) error {
 	if c.db == nil {
 		return ErrBadConn
 	}
-	db += "\x00"
-	rc := C.codec_key(c.db, cStr(db), cBytes(key), C.int(len(key)))
+	zDb := db + "\x00"
+	rc := C.codec_key(c.db, cStr(zDb), cBytes(key), C.int(len(key)))
 	if rc != OK {
 		if rc == -1 {
-			return pkgErr(ERROR, "codec support is disabled")
+			return ErrNoCodec
 		}
 		return libErr(rc, c.db)
 	}
+	if err := c.checkKey(db); err != nil && errCode(err) == NOTADB {
+		return err
+	}
 	return nil
 }
 
+// KeyCheck returns true if the current codec key, if any, can be used to decode
+// an attached database. It returns false and a nil error if page 1 of the
+// database cannot be decoded, which usually means that the key is invalid.
+// Other errors (e.g. BUSY) are returned as is.
+func (c *Conn) KeyCheck(db string) (ok bool, err error) {
+	if c.db == nil {
+		return false, ErrBadConn
+	}
+	if err = c.checkKey(db); err != nil {
+		if errCode(err) == NOTADB {
+			err = nil
+		}
+		return false, err
+	}
+	return true, nil
+}
+
 // Rekey changes the codec key for an attached database. This is not currently
 // implemented for Go codecs.
 func (c *Conn) Rekey(db string, key []byte) error {
@@ -495,13 +649,30 @@ func (c *Conn) Rekey(db string, key []byte) error {
 	rc := C.codec_rekey(c.db, cStr(db), cBytes(key), C.int(len(key)))
 	if rc != OK {
 		if rc == -1 {
-			return pkgErr(ERROR, "codec support is disabled")
+			return ErrNoCodec
 		}
 		return libErr(rc, c.db)
 	}
 	return nil
 }
 
+// CodecError returns the last error reported by the codec of an attached
+// database, or nil if the codec never failed. SQLite does not provide a way for
+// codecs to report errors, so any failure is seen as an out of memory
+// condition. This package replaces such errors with an Error that has the
+// codec's message and a NOTADB (page 1 could not be decoded, which usually
+// indicates an invalid key), CORRUPT (any other page could not be decoded), or
+// codec-specific result code.
+func (c *Conn) CodecError(db string) error {
+	if c.db == nil {
+		return ErrBadConn
+	}
+	if err := lastCodecErr(c.db, db); err != nil {
+		return err
+	}
+	return nil
+}
+
 // Path returns the full file path of an attached database. An empty string is
 // returned for temporary databases.
 // [http://www.sqlite.org/c3ref/db_filename.html]
@@ -542,6 +713,13 @@ func (c *Conn) Limit(id, value int) (prev int) {
 	return
 }
 
+// checkKey reads the schema of an attached database, which requires page 1 to
+// be decoded by the codec.
+func (c *Conn) checkKey(db string) error {
+	sql := "SELECT count(*) FROM " + quoteIdent(db) + ".sqlite_master\x00"
+	return c.exec(cStr(sql))
+}
+
 // exec calls sqlite3_exec on sql, which must be a null-terminated C string.
 func (c *Conn) exec(sql *C.char) error {
 	if rc := C.sqlite3_exec(c.db, sql, nil, nil, nil); rc != OK {
@@ -575,6 +753,8 @@ type Stmt struct {
 	colNames []string // Names of columns in the result set
 	colDecls []string // Column type declarations in upper case
 	colTypes []uint8  // Data type codes for all columns in the current row
+
+	run *stmtRun // Instrumentation state for the current run
 }
 
 // newStmt creates a new prepared statement.
@@ -585,7 +765,7 @@ func newStmt(c *Conn, sql string) (*Stmt, error) {
 	var tail *C.char
 	rc := C.sqlite3_prepare_v2(c.db, cStr(zSql), -1, &stmt, &tail)
 	if rc != OK {
-		return nil, libErr(rc, c.db)
+		return nil, sqlErr(libErr(rc, c.db), sql, c.db)
 	}
 
 	// stmt will be nil if sql contained only comments or whitespace. s.Tail may
@@ -596,6 +776,7 @@ func newStmt(c *Conn, sql string) (*Stmt, error) {
 			s.varNames = unnamedVars
 		}
 		s.nCols = int(C.sqlite3_column_count(stmt))
+		c.ref()
 		runtime.SetFinalizer(s, (*Stmt).Close)
 	}
 	if tail != nil {
@@ -612,9 +793,14 @@ func newStmt(c *Conn, sql string) (*Stmt, error) {
 // [http://www.sqlite.org/c3ref/finalize.html]
 func (s *Stmt) Close() error {
 	if stmt := s.stmt; stmt != nil {
+		if s.run != nil {
+			s.end(nil)
+		}
 		*s = Stmt{Tail: s.Tail, conn: s.conn, text: s.text}
 		runtime.SetFinalizer(s, nil)
-		if rc := C.sqlite3_finalize(stmt); rc != OK {
+		rc := C.sqlite3_finalize(stmt)
+		s.conn.unref()
+		if rc != OK {
 			return libErr(rc, s.conn.db)
 		}
 	}
@@ -841,6 +1027,9 @@ func (s *Stmt) Reset() {
 		if s.nVars > 0 {
 			C.sqlite3_clear_bindings(s.stmt)
 		}
+		if s.run != nil {
+			s.end(nil)
+		}
 	}
 }
 
@@ -867,6 +1056,9 @@ func (s *Stmt) exec(args []interface{}) (err error) {
 		err = s.bindUnnamed(args)
 	}
 	if err == nil {
+		if s.conn.instr != nil {
+			s.begin(s.conn.instr)
+		}
 		err = s.step()
 		if s.nCols > 0 {
 			// If the statement was recompiled (v2 interface, no indication),
@@ -940,6 +1132,10 @@ func (s *Stmt) bind(i C.int, v interface{}, name string) error {
 		rc = C.bind_blob(s.stmt, i, cBytes(v), C.int(len(v)), 0)
 	case ZeroBlob:
 		rc = C.sqlite3_bind_zeroblob(s.stmt, i, C.int(v))
+	case JSON:
+		return s.bindJSON(i, v.V)
+	case json.Marshaler:
+		return s.bindJSON(i, v)
 	default:
 		if name != "" {
 			return pkgErr(MISUSE, "unsupported type for %s (%T)", name, v)
@@ -952,9 +1148,22 @@ func (s *Stmt) bind(i C.int, v interface{}, name string) error {
 	return nil
 }
 
+// bindJSON binds v to the parameter at index i as JSON text.
+func (s *Stmt) bindJSON(i C.int, v interface{}) error {
+	b, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	return s.bind(i, string(b), "")
+}
+
 // step evaluates the next step in the statement's program, automatically
 // resetting the statement if the result is anything other than SQLITE_ROW.
-func (s *Stmt) step() error {
+func (s *Stmt) step() (err error) {
+	var t time.Time
+	if s.run != nil {
+		t = time.Now()
+	}
 	s.colTypes = s.colTypes[:0]
 	s.haveRow = C.sqlite3_step(s.stmt) == ROW
 	if !s.haveRow {
@@ -965,10 +1174,18 @@ func (s *Stmt) step() error {
 			C.sqlite3_clear_bindings(s.stmt)
 		}
 		if rc != OK {
-			return libErr(rc, s.conn.db)
+			err = sqlErr(libErr(rc, s.conn.db), s.text, nil)
 		}
 	}
-	return nil
+	if s.run != nil {
+		s.run.Duration += time.Since(t)
+		if s.haveRow {
+			s.run.Rows++
+		} else {
+			s.end(err)
+		}
+	}
+	return
 }
 
 // colType returns the data type code of column i in the current row (one of
@@ -1018,6 +1235,10 @@ func (s *Stmt) scan(i C.int, v interface{}) error {
 		if _, err := v.Write(blob(s.stmt, i, false)); err != nil {
 			return err
 		}
+	case JSON:
+		return s.scanJSON(i, v.V)
+	case json.Unmarshaler:
+		return s.scanJSON(i, v)
 	default:
 		return pkgErr(MISUSE, "unscannable type for column %d (%T)", int(i), v)
 	}
@@ -1052,12 +1273,29 @@ func (s *Stmt) scanZero(i C.int, v interface{}) error {
 	case *RawBytes:
 		*v = nil
 	case io.Writer:
+	case JSON:
+		return s.scanJSON(i, v.V)
+	case json.Unmarshaler:
+		return s.scanJSON(i, v)
 	default:
 		return pkgErr(MISUSE, "unscannable type for column %d (%T)", int(i), v)
 	}
 	return nil
 }
 
+// scanJSON decodes the value of column i into v, which must be a pointer. NULL
+// is decoded as JSON null.
+func (s *Stmt) scanJSON(i C.int, v interface{}) error {
+	b := []byte("null")
+	if s.colType(i) != NULL {
+		b = blob(s.stmt, i, false)
+	}
+	if err := json.Unmarshal(b, v); err != nil {
+		return pkgErr(MISUSE, "cannot decode column %d as JSON: %v", int(i), err)
+	}
+	return nil
+}
+
 // scanDynamic scans the value of column i (starting at 0) into v, using the
 // column's data type and declaration to select an appropriate representation.
 // If driverValue is true, the range of possible representations is restricted
@@ -6,15 +6,20 @@ package sqlite3_test
 
 import (
 	"bytes"
+	"context"
 	"database/sql"
+	"encoding/json"
+	"errors"
 	"flag"
 	"fmt"
 	"io"
 	"io/ioutil"
 	"math"
 	"os"
+	"os/exec"
 	"reflect"
 	"runtime"
+	"strconv"
 	"strings"
 	"testing"
 	"time"
@@ -60,6 +65,15 @@ func (t T) open(name string) *Conn {
 	return c
 }
 
+// needCodec skips the test if codec support is disabled.
+func (t T) needCodec() {
+	c := t.open(":memory:")
+	defer t.close(c)
+	if err := c.Key("main", nil); err == ErrNoCodec {
+		t.Skip("codec support is disabled")
+	}
+}
+
 func (t T) close(c io.Closer) {
 	if c != nil {
 		if db, _ := c.(*Conn); db != nil {
@@ -1022,6 +1036,104 @@ func TestBackup(T *testing.T) {
 	t.next(s, io.EOF)
 }
 
+func TestBackupTo(T *testing.T) {
+	t := begin(T)
+
+	c := t.open(":memory:")
+	defer t.close(c)
+	t.exec(c, "CREATE TABLE x(a)")
+	for i := 0; i < 10; i++ {
+		t.exec(c, "INSERT INTO x VALUES(?)", strings.Repeat("x", 1000))
+	}
+
+	// Canceled
+	tmp := t.tmpFile()
+	defer os.Remove(tmp)
+	os.Remove(tmp)
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	err := c.BackupToFile(ctx, tmp, &BackupOptions{PagesPerStep: 1})
+	if err != context.Canceled {
+		t.Fatalf("c.BackupToFile() expected %v; got %v", context.Canceled, err)
+	}
+	if _, err := os.Stat(tmp); !os.IsNotExist(err) {
+		t.Fatalf("os.Stat() expected a missing file; got %v", err)
+	}
+
+	// Complete
+	var steps, last int
+	opts := &BackupOptions{
+		PagesPerStep: 2,
+		Delay:        time.Millisecond,
+		Progress: func(remaining, total int) {
+			steps++
+			last = remaining
+		},
+	}
+	if err = c.BackupToFile(context.Background(), tmp, opts); err != nil {
+		t.Fatalf("c.BackupToFile() unexpected error: %v", err)
+	}
+	if steps < 2 || last != 0 {
+		t.Fatalf("opts.Progress() expected >= 2 steps ending at 0; got %d, %d", steps, last)
+	}
+	if fi, err := os.Stat(tmp); err != nil {
+		t.Fatalf("os.Stat() unexpected error: %v", err)
+	} else if runtime.GOOS != "windows" && fi.Mode().Perm() != 0644 {
+		t.Fatalf("fi.Mode() expected 0644; got %#o", fi.Mode().Perm())
+	}
+
+	// Verify
+	c2 := t.open(tmp)
+	defer t.close(c2)
+	s := t.query(c2, "SELECT count(*) FROM x")
+	defer t.close(s)
+	var n int
+	t.scan(s, &n)
+	if n != 10 {
+		t.Fatalf("s.Scan() expected 10; got %d", n)
+	}
+	t.close(s)
+
+	// Busy destination with a deadline
+	t.exec(c2, "BEGIN EXCLUSIVE")
+	dst := t.open(tmp)
+	defer t.close(dst)
+	ctx, cancel = context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	opts = &BackupOptions{Delay: 10 * time.Millisecond}
+	if err = c.BackupTo(ctx, dst, opts); err != context.DeadlineExceeded {
+		t.Fatalf("c.BackupTo() expected %v; got %v", context.DeadlineExceeded, err)
+	}
+
+	// Busy source without a deadline
+	src := t.open(tmp)
+	defer t.close(src)
+	mem := t.open(":memory:")
+	defer t.close(mem)
+	unlock := make(chan error, 1)
+	go func() {
+		time.Sleep(50 * time.Millisecond)
+		unlock <- c2.Exec("ROLLBACK")
+	}()
+	start := time.Now()
+	opts = &BackupOptions{Delay: 5 * time.Millisecond}
+	if err = src.BackupTo(context.Background(), mem, opts); err != nil {
+		t.Fatalf("src.BackupTo() unexpected error: %v", err)
+	}
+	if err = <-unlock; err != nil {
+		t.Fatalf("c2.Exec(ROLLBACK) unexpected error: %v", err)
+	}
+	if d := time.Since(start); d < 50*time.Millisecond {
+		t.Fatalf("src.BackupTo() finished before the lock was released (%v)", d)
+	}
+	s = t.query(mem, "SELECT count(*) FROM x")
+	t.scan(s, &n)
+	t.close(s)
+	if n != 10 {
+		t.Fatalf("s.Scan() expected 10; got %d", n)
+	}
+}
+
 func TestBusyHandler(T *testing.T) {
 	t := begin(T)
 
@@ -1305,3 +1417,1265 @@ func TestDriver(T *testing.T) {
 		t.Fatalf("rows.Err() unexpected error: %v", err)
 	}
 }
+
+// pageStat is a pass-through codec that counts the pages encoded by the
+// compress codec, which precedes it in a chain, with and without compression.
+type pageStat struct {
+	packed, stored int
+}
+
+func (c *pageStat) Reserve() int    { return 0 }
+func (c *pageStat) Resize(_, _ int) {}
+func (c *pageStat) Key() []byte     { return []byte("pagestat") }
+func (c *pageStat) Free()           {}
+
+func (c *pageStat) Decode(p []byte, n uint32, op int) *Error {
+	return nil
+}
+
+func (c *pageStat) Encode(p []byte, n uint32, op int) ([]byte, *Error) {
+	// The last 4 bytes contain the length of the compressed data (0 = stored)
+	if i := len(p) - 4; p[i]|p[i+1]|p[i+2]|p[i+3] != 0 {
+		c.packed++
+	} else {
+		c.stored++
+	}
+	return p, nil
+}
+
+func TestCodecChain(T *testing.T) {
+	t := begin(T)
+	t.needCodec()
+
+	stat := new(pageStat)
+	RegisterCodec("pagestat", func(*CodecCtx, []byte) (Codec, *Error) {
+		return stat, nil
+	})
+	defer RegisterCodec("pagestat", nil)
+
+	tmp := t.tmpFile()
+	defer os.Remove(tmp)
+	key := "compress+pagestat+aes-hmac::secretkey1"
+	text := strings.Repeat("hello, world ", 100)
+
+	// Create
+	c := t.open(tmp)
+	if err := c.Key("main", []byte(key)); err != nil {
+		t.Fatalf("c.Key(%q) unexpected error: %v", key, err)
+	}
+	t.exec(c, "CREATE TABLE x(a)")
+	t.exec(c, "INSERT INTO x VALUES(?)", text)
+	if err := c.Close(); err != nil {
+		t.Fatalf("c.Close() unexpected error: %v", err)
+	}
+	if b, _ := ioutil.ReadFile(tmp); bytes.Contains(b, []byte("hello, world")) {
+		t.Fatalf("plaintext found in the encoded database file")
+	}
+	if stat.packed == 0 {
+		t.Fatalf("no compressed pages (%d stored)", stat.stored)
+	}
+
+	// Verify
+	c = t.open(tmp)
+	defer t.close(c)
+	if err := c.Key("main", []byte(key)); err != nil {
+		t.Fatalf("c.Key(%q) unexpected error: %v", key, err)
+	}
+	s := t.query(c, "SELECT a FROM x")
+	defer t.close(s)
+	var have string
+	t.scan(s, &have)
+	if have != text {
+		t.Fatalf("s.Scan() expected %q; got %q", text, have)
+	}
+	t.next(s, io.EOF)
+}
+
+// keyCodec is a pass-through codec that records its key. It does not reserve a
+// fixed number of bytes if the key contains the "any" option.
+type keyCodec struct {
+	key []byte
+}
+
+func (c *keyCodec) Reserve() int {
+	if bytes.Contains(c.key, []byte(":any")) {
+		return -1
+	}
+	return 1
+}
+func (c *keyCodec) Resize(_, _ int) {}
+func (c *keyCodec) Key() []byte     { return c.key }
+func (c *keyCodec) Free()           {}
+
+func (c *keyCodec) Decode(p []byte, n uint32, op int) *Error {
+	return nil
+}
+
+func (c *keyCodec) Encode(p []byte, n uint32, op int) ([]byte, *Error) {
+	return p, nil
+}
+
+func TestCodecChainOptions(T *testing.T) {
+	t := begin(T)
+
+	var keys []string
+	f := func(_ *CodecCtx, key []byte) (Codec, *Error) {
+		keys = append(keys, string(key))
+		return &keyCodec{key}, nil
+	}
+	RegisterCodec("one", f)
+	RegisterCodec("two", f)
+	defer RegisterCodec("one", nil)
+	defer RegisterCodec("two", nil)
+
+	key := "one+two:one.x=1.5,y,two.z:secret"
+	ci, err := NewCodec(&CodecCtx{}, []byte(key))
+	if err != nil {
+		t.Fatalf("NewCodec(%q) unexpected error: %v", key, err)
+	}
+	defer ci.Free()
+	if want := []string{"one:x=1.5", "two:y,z:secret"}; !reflect.DeepEqual(keys, want) {
+		t.Fatalf("codec keys expected %q; got %q", want, keys)
+	}
+	if have, want := string(ci.Key()), "one+two:one.x=1.5,y,z:secret"; have != want {
+		t.Fatalf("ci.Key() expected %q; got %q", want, have)
+	}
+	if n := ci.Reserve(); n != 2 {
+		t.Fatalf("ci.Reserve() expected 2; got %d", n)
+	}
+
+	for _, key := range []string{
+		"one+two:three.x:secret", // Not in the chain
+		"one+two:one.any:secret", // Reserve returns -1
+	} {
+		_, err := NewCodec(&CodecCtx{}, []byte(key))
+		t.errCode(err, MISUSE)
+	}
+}
+
+func TestCodecError(T *testing.T) {
+	t := begin(T)
+	t.needCodec()
+
+	tmp := t.tmpFile()
+	defer os.Remove(tmp)
+	key := []byte("aes-hmac::secretkey1")
+
+	// Create
+	c := t.open(tmp)
+	if err := c.Key("main", key); err != nil {
+		t.Fatalf("c.Key() unexpected error: %v", err)
+	}
+	t.exec(c, "CREATE TABLE x(a)")
+	t.exec(c, "INSERT INTO x VALUES(?)", strings.Repeat("hello, world ", 100))
+	if err := c.CodecError("main"); err != nil {
+		t.Fatalf("c.CodecError() unexpected error: %v", err)
+	}
+	if err := c.Close(); err != nil {
+		t.Fatalf("c.Close() unexpected error: %v", err)
+	}
+
+	// Invalid key
+	c = t.open(tmp)
+	err := c.Key("main", []byte("aes-hmac::secretkey2"))
+	t.errCode(err, NOTADB)
+	if ok, err := c.KeyCheck("main"); ok || err != nil {
+		t.Fatalf("c.KeyCheck() expected false, <nil>; got %t, %v", ok, err)
+	}
+	_, err = c.Query("SELECT * FROM x")
+	t.errCode(err, NOTADB)
+	if err := c.CodecError("main"); err == nil {
+		t.Fatalf("c.CodecError() expected an error")
+	}
+	if err := c.CodecError("temp"); err != nil {
+		t.Fatalf("c.CodecError() unexpected error: %v", err)
+	}
+
+	// Valid key
+	if err := c.Key("main", key); err != nil {
+		t.Fatalf("c.Key() unexpected error: %v", err)
+	}
+	if ok, err := c.KeyCheck("main"); !ok || err != nil {
+		t.Fatalf("c.KeyCheck() expected true, <nil>; got %t, %v", ok, err)
+	}
+	if err := c.Close(); err != nil {
+		t.Fatalf("c.Close() unexpected error: %v", err)
+	}
+
+	// Invalid key in ATTACH
+	c = t.open(":memory:")
+	err = c.Exec("ATTACH ? AS two KEY 'aes-hmac::secretkey2'", tmp)
+	t.errCode(err, NOTADB)
+	if err := c.Close(); err != nil {
+		t.Fatalf("c.Close() unexpected error: %v", err)
+	}
+
+	// Tampered page
+	b, err := ioutil.ReadFile(tmp)
+	if err != nil {
+		t.Fatalf("ioutil.ReadFile() unexpected error: %v", err)
+	}
+	b[len(b)-100] ^= 1
+	if err = ioutil.WriteFile(tmp, b, 0666); err != nil {
+		t.Fatalf("ioutil.WriteFile() unexpected error: %v", err)
+	}
+	c = t.open(tmp)
+	defer t.close(c)
+	if err := c.Key("main", key); err != nil {
+		t.Fatalf("c.Key() unexpected error: %v", err)
+	}
+	_, err = c.Query("SELECT * FROM x")
+	t.errCode(err, CORRUPT)
+}
+
+func TestCodecBackup(T *testing.T) {
+	t := begin(T)
+	t.needCodec()
+
+	tmp := t.tmpFile()
+	defer os.Remove(tmp)
+	src := t.open(tmp)
+	defer t.close(src)
+	if err := src.Key("main", []byte("aes-hmac::secretkey1")); err != nil {
+		t.Fatalf("src.Key() unexpected error: %v", err)
+	}
+	t.exec(src, "PRAGMA page_size=1024")
+	t.exec(src, "CREATE TABLE x(a)")
+	t.exec(src, "INSERT INTO x VALUES(?)", strings.Repeat("hello, world ", 100))
+
+	// Page size is changed to match the source
+	tmp = t.tmpFile()
+	defer os.Remove(tmp)
+	dst := t.open(tmp)
+	if err := dst.Key("main", []byte("aes-hmac::secretkey2")); err != nil {
+		t.Fatalf("dst.Key() unexpected error: %v", err)
+	}
+	t.exec(dst, "PRAGMA page_size=4096")
+	b, err := src.Backup("main", dst, "main")
+	if err != nil {
+		t.Fatalf("src.Backup() unexpected error: %v", err)
+	}
+	if err = b.Step(-1); err != io.EOF {
+		t.Fatalf("b.Step(-1) expected EOF; got %v", err)
+	}
+	if err = b.Close(); err != nil {
+		t.Fatalf("b.Close() unexpected error: %v", err)
+	}
+	s := t.query(dst, "PRAGMA page_size")
+	var n int
+	t.scan(s, &n)
+	t.close(s)
+	if n != 1024 {
+		t.Fatalf("page_size expected 1024; got %d", n)
+	}
+	s = t.query(dst, "SELECT count(*) FROM x")
+	t.scan(s, &n)
+	t.close(s)
+	if n != 1 {
+		t.Fatalf("count(*) expected 1; got %d", n)
+	}
+	t.close(dst)
+
+	// Reserve values are determined by the codecs and cannot be changed
+	tmp = t.tmpFile()
+	defer os.Remove(tmp)
+	dst = t.open(tmp)
+	defer t.close(dst)
+	if err := dst.Key("main", []byte("aead::secretkey2")); err != nil {
+		t.Fatalf("dst.Key() unexpected error: %v", err)
+	}
+	_, err = src.Backup("main", dst, "main")
+	t.errCode(err, READONLY)
+
+	// BackupTo copies the contents with SQL statements instead
+	if err = src.BackupTo(context.Background(), dst, nil); err != nil {
+		t.Fatalf("src.BackupTo() unexpected error: %v", err)
+	}
+	s = t.query(dst, "SELECT count(*) FROM x")
+	t.scan(s, &n)
+	t.close(s)
+	if n != 1 {
+		t.Fatalf("count(*) expected 1; got %d", n)
+	}
+}
+
+func TestSerialize(T *testing.T) {
+	t := begin(T)
+
+	c := t.open(":memory:")
+	defer t.close(c)
+	t.exec(c, "CREATE TABLE x(a)")
+	t.exec(c, "INSERT INTO x VALUES('hello, world')")
+	b, err := c.Serialize("")
+	if err != nil {
+		t.Fatalf("c.Serialize() unexpected error: %v", err)
+	}
+	if !bytes.HasPrefix(b, []byte("SQLite format 3\x00")) {
+		t.Fatalf("c.Serialize() returned an invalid database image")
+	}
+
+	count := func(c *Conn, tbl string) (n int) {
+		s := t.query(c, "SELECT count(*) FROM "+tbl)
+		t.scan(s, &n)
+		t.close(s)
+		return
+	}
+
+	// Main database
+	d := t.open(":memory:")
+	defer t.close(d)
+	if err = d.Deserialize("main", b, false); err != nil {
+		t.Fatalf("d.Deserialize() unexpected error: %v", err)
+	}
+	if n := count(d, "x"); n != 1 {
+		t.Fatalf("count(*) expected 1; got %d", n)
+	}
+	t.errCode(d.Deserialize("main", b, true), MISUSE)
+
+	// Attached database
+	if err = d.Deserialize("two", b, false); err != nil {
+		t.Fatalf("d.Deserialize() unexpected error: %v", err)
+	}
+	t.exec(d, "INSERT INTO two.x VALUES(1)")
+	if n := count(d, "two.x"); n != 2 {
+		t.Fatalf("count(*) expected 2; got %d", n)
+	}
+	if b2, err := d.Serialize("two"); err != nil {
+		t.Fatalf("d.Serialize() unexpected error: %v", err)
+	} else if len(b2) != len(b) || bytes.Equal(b2, b) {
+		t.Fatalf("d.Serialize() returned an unexpected database image")
+	}
+
+	// Replaced with a read-only copy
+	if err = d.Deserialize("two", b, true); err != nil {
+		t.Fatalf("d.Deserialize() unexpected error: %v", err)
+	}
+	if n := count(d, "two.x"); n != 1 {
+		t.Fatalf("count(*) expected 1; got %d", n)
+	}
+	t.errCode(d.Exec("INSERT INTO two.x VALUES(1)"), READONLY)
+	t.exec(d, "DETACH two")
+
+	// Invalid image
+	err = d.Deserialize("three", bytes.Repeat([]byte("x"), 1024), false)
+	if err == nil {
+		_, err = d.Query("SELECT * FROM three.sqlite_master")
+	}
+	t.errCode(err, NOTADB)
+}
+
+func TestCodecSerialize(T *testing.T) {
+	t := begin(T)
+	t.needCodec()
+
+	tmp := t.tmpFile()
+	defer os.Remove(tmp)
+	key := []byte("aes-hmac::secretkey1")
+	c := t.open(tmp)
+	defer t.close(c)
+	if err := c.Key("main", key); err != nil {
+		t.Fatalf("c.Key() unexpected error: %v", err)
+	}
+	t.exec(c, "CREATE TABLE x(a)")
+	t.exec(c, "INSERT INTO x VALUES('hello, world')")
+
+	// Pages are decoded, but the reserve value of the codec is preserved
+	b, err := c.Serialize("main")
+	if err != nil {
+		t.Fatalf("c.Serialize() unexpected error: %v", err)
+	}
+	if !bytes.Contains(b, []byte("hello, world")) {
+		t.Fatalf("c.Serialize() returned encoded pages")
+	}
+	if len(b) < 100 || b[20] == 0 {
+		t.Fatalf("c.Serialize() did not preserve the reserve value")
+	}
+
+	// The image can be restored into an encoded database with the same reserve
+	tmp2 := t.tmpFile()
+	defer os.Remove(tmp2)
+	d := t.open(tmp2)
+	defer t.close(d)
+	if err := d.Key("main", []byte("aes-hmac::secretkey2")); err != nil {
+		t.Fatalf("d.Key() unexpected error: %v", err)
+	}
+	if err = d.Deserialize("main", b, false); err != nil {
+		t.Fatalf("d.Deserialize() unexpected error: %v", err)
+	}
+	if b2, _ := ioutil.ReadFile(tmp2); bytes.Contains(b2, []byte("hello, world")) {
+		t.Fatalf("plaintext found in the encoded database file")
+	}
+
+	// Attached images do not use the key of the main database
+	if err = d.Deserialize("two", b, true); err != nil {
+		t.Fatalf("d.Deserialize() unexpected error: %v", err)
+	}
+	s := t.query(d, "SELECT a FROM two.x")
+	defer t.close(s)
+	var have string
+	t.scan(s, &have)
+	if have != "hello, world" {
+		t.Fatalf("s.Scan() expected %q; got %q", "hello, world", have)
+	}
+}
+
+func TestDump(T *testing.T) {
+	t := begin(T)
+
+	c := t.open(":memory:")
+	defer t.close(c)
+	t.exec(c, `
+		CREATE TABLE x(a INTEGER PRIMARY KEY AUTOINCREMENT, b, "c""d");
+		CREATE INDEX x_b ON x(b);
+		CREATE TABLE y(a REFERENCES x);
+		CREATE VIEW v AS SELECT b FROM x;
+		CREATE TRIGGER t AFTER INSERT ON x BEGIN INSERT INTO y VALUES(new.a); END;
+		INSERT INTO x VALUES(1, NULL, 1);
+		INSERT INTO x VALUES(2, 1.0, 'it''s');
+		INSERT INTO x VALUES(3, x'00ff', 'multi
+line');
+		INSERT INTO x VALUES(4, -2.5e-300, 9223372036854775807);
+		DELETE FROM x WHERE a=4;
+	`)
+
+	var buf bytes.Buffer
+	if err := c.Dump(&buf, "main", nil); err != nil {
+		t.Fatalf("c.Dump() unexpected error: %v", err)
+	}
+	dump := buf.String()
+	for _, want := range []string{
+		`INSERT INTO "x" VALUES(2,1.0,'it''s');`,
+		`INSERT INTO "x" VALUES(3,X'00ff','multi` + "\n" + `line');`,
+		`DELETE FROM sqlite_sequence;`,
+		`INSERT INTO "sqlite_sequence" VALUES('x',4);`,
+	} {
+		if !strings.Contains(dump, want) {
+			t.Fatalf("c.Dump() output does not contain %q:\n%s", want, dump)
+		}
+	}
+	if i, j := strings.Index(dump, `INSERT INTO "y"`), strings.Index(dump,
+		"CREATE TRIGGER"); i < 0 || j < i {
+		t.Fatalf("c.Dump() triggers must follow data:\n%s", dump)
+	}
+
+	// Restore into a new database and compare
+	d := t.open(":memory:")
+	defer t.close(d)
+	if err := d.Restore(strings.NewReader(dump)); err != nil {
+		t.Fatalf("d.Restore() unexpected error: %v", err)
+	}
+	buf.Reset()
+	if err := d.Dump(&buf, "", nil); err != nil {
+		t.Fatalf("d.Dump() unexpected error: %v", err)
+	}
+	if buf.String() != dump {
+		t.Fatalf("d.Dump() expected:\n%s\ngot:\n%s", dump, buf.String())
+	}
+	s := t.query(d, "SELECT typeof(b) FROM x WHERE a=2")
+	var typ string
+	t.scan(s, &typ)
+	t.close(s)
+	if typ != "real" {
+		t.Fatalf("typeof(b) expected real; got %s", typ)
+	}
+
+	// Schema only
+	buf.Reset()
+	if err := c.Dump(&buf, "main", &DumpOptions{SchemaOnly: true}); err != nil {
+		t.Fatalf("c.Dump() unexpected error: %v", err)
+	}
+	if strings.Contains(buf.String(), "INSERT INTO \"") {
+		t.Fatalf("c.Dump() unexpected INSERT statement:\n%s", buf.String())
+	}
+
+	// Failed restore is rolled back
+	e := t.open(":memory:")
+	defer t.close(e)
+	err := e.Restore(strings.NewReader("CREATE TABLE z(a);\nINSERT INTO q VALUES(1);"))
+	t.errCode(err, ERROR)
+	_, err = e.Query("SELECT * FROM z")
+	t.errCode(err, ERROR)
+
+	// Semicolons in literals, identifiers, and comments
+	in := "CREATE TABLE \"z;\"([a;]); -- z;\nINSERT INTO \"z;\" VALUES('1;\n2'); /* ;\n" +
+		"*/ INSERT INTO \"z;\" VALUES(3);\n"
+	if err = e.Restore(strings.NewReader(in)); err != nil {
+		t.Fatalf("e.Restore() unexpected error: %v", err)
+	}
+	s = t.query(e, `SELECT group_concat("a;", '|') FROM "z;"`)
+	var all string
+	t.scan(s, &all)
+	t.close(s)
+	if want := "1;\n2|3"; all != want {
+		t.Fatalf("restored rows expected %q; got %q", want, all)
+	}
+
+	// Generated columns are not inserted
+	if VersionNum() >= 3031000 {
+		t.exec(c, "CREATE TABLE g(a, b AS (a*2), c AS (a+1) STORED)")
+		t.exec(c, "INSERT INTO g(a) VALUES(21)")
+		buf.Reset()
+		if err = c.Dump(&buf, "", &DumpOptions{Tables: []string{"g"}}); err != nil {
+			t.Fatalf("c.Dump() unexpected error: %v", err)
+		}
+		if want := `INSERT INTO "g"("a") VALUES(21);`; !strings.Contains(buf.String(), want) {
+			t.Fatalf("c.Dump() output does not contain %q:\n%s", want, buf.String())
+		}
+		if err = e.Restore(&buf); err != nil {
+			t.Fatalf("e.Restore() unexpected error: %v", err)
+		}
+		s = t.query(e, "SELECT b, c FROM g")
+		var b, gc int
+		t.scan(s, &b, &gc)
+		t.close(s)
+		if b != 42 || gc != 22 {
+			t.Fatalf("generated columns expected 42, 22; got %d, %d", b, gc)
+		}
+	}
+}
+
+func TestCSV(T *testing.T) {
+	t := begin(T)
+
+	c := t.open(":memory:")
+	defer t.close(c)
+	in := "id,name,score\n1,\"Smith, J\",1.5\n2,\"say \"\"hi\"\"\",\n3,,2\n"
+	err := c.ImportCSV("x", strings.NewReader(in), &CSVOptions{EmptyNull: true})
+	if err != nil {
+		t.Fatalf("c.ImportCSV() unexpected error: %v", err)
+	}
+	s := t.query(c, "SELECT group_concat(typeof(id)||typeof(name)||typeof(score),' ') FROM x")
+	var types string
+	t.scan(s, &types)
+	t.close(s)
+	if want := "integertextreal integertextnull integernullreal"; types != want {
+		t.Fatalf("column types expected %q; got %q", want, types)
+	}
+
+	// Append to an existing table using different column order
+	in = "score,id\n3,4\n"
+	if err = c.ImportCSV("x", strings.NewReader(in), nil); err != nil {
+		t.Fatalf("c.ImportCSV() unexpected error: %v", err)
+	}
+
+	// Invalid records are rolled back
+	in = "5,a,1\n6,b\n"
+	err = c.ImportCSV("x", strings.NewReader(in), &CSVOptions{NoHeader: true})
+	t.errCode(err, ERROR)
+
+	var buf bytes.Buffer
+	s = t.prepare(c, "SELECT * FROM x ORDER BY id")
+	if err = s.WriteCSV(&buf); err != nil {
+		t.Fatalf("s.WriteCSV() unexpected error: %v", err)
+	}
+	t.close(s)
+	want := "id,name,score\n1,\"Smith, J\",1.5\n2,\"say \"\"hi\"\"\",\n3,,2.0\n4,,3.0\n"
+	if buf.String() != want {
+		t.Fatalf("s.WriteCSV() expected %q; got %q", want, buf.String())
+	}
+}
+
+func TestJSON(T *testing.T) {
+	t := begin(T)
+
+	type doc struct {
+		Name string
+		Tags []string
+	}
+	c := t.open(":memory:")
+	defer t.close(c)
+	t.exec(c, "CREATE TABLE x(a INTEGER, b TEXT, c BLOB, d REAL)")
+	t.exec(c, "INSERT INTO x VALUES(?,?,?,?)", 1, JSON{doc{"a", []string{"x"}}},
+		[]byte{0xff}, 1.5)
+	t.exec(c, "INSERT INTO x VALUES(?,?,NULL,NULL)", 2, json.RawMessage(`{"Name":"b"}`))
+	t.exec(c, "INSERT INTO x VALUES(3,NULL,NULL,NULL)")
+
+	// Scan into a struct
+	s := t.query(c, "SELECT b FROM x ORDER BY a")
+	var have []doc
+	for i := 0; i < 3; i++ {
+		var d *doc
+		t.scan(s, JSON{&d})
+		if d != nil {
+			have = append(have, *d)
+		}
+		s.Next()
+	}
+	t.close(s)
+	if want := []doc{{"a", []string{"x"}}, {"b", nil}}; !reflect.DeepEqual(have, want) {
+		t.Fatalf("JSON{} expected %v; got %v", want, have)
+	}
+	var raw json.RawMessage
+	s = t.query(c, "SELECT b FROM x WHERE a=2")
+	t.scan(s, &raw)
+	t.close(s)
+	if string(raw) != `{"Name":"b"}` {
+		t.Fatalf("json.RawMessage expected %q; got %q", `{"Name":"b"}`, raw)
+	}
+
+	// Export
+	var buf bytes.Buffer
+	s = t.prepare(c, "SELECT a, c, d FROM x ORDER BY a")
+	if err := s.WriteJSON(&buf, JSONArray); err != nil {
+		t.Fatalf("s.WriteJSON() unexpected error: %v", err)
+	}
+	want := `[{"a":1,"c":"/w==","d":1.5},{"a":2,"c":null,"d":null},` +
+		`{"a":3,"c":null,"d":null}]` + "\n"
+	if buf.String() != want {
+		t.Fatalf("s.WriteJSON() expected %q; got %q", want, buf.String())
+	}
+	buf.Reset()
+	if err := s.WriteJSON(&buf, JSONLines); err != nil {
+		t.Fatalf("s.WriteJSON() unexpected error: %v", err)
+	}
+	t.close(s)
+	if n := strings.Count(buf.String(), "\n"); n != 3 {
+		t.Fatalf("s.WriteJSON() expected 3 lines; got %d", n)
+	}
+
+	// Empty result
+	for format, want := range map[int]string{JSONArray: "[]\n", JSONLines: ""} {
+		buf.Reset()
+		s = t.prepare(c, "SELECT a FROM x WHERE a > 3")
+		if err := s.WriteJSON(&buf, format); err != nil {
+			t.Fatalf("s.WriteJSON(%d) unexpected error: %v", format, err)
+		}
+		t.close(s)
+		if buf.String() != want {
+			t.Fatalf("s.WriteJSON(%d) expected %q; got %q", format, want, buf.String())
+		}
+	}
+}
+
+func TestFormatter(T *testing.T) {
+	t := begin(T)
+
+	c := t.open(":memory:")
+	defer t.close(c)
+	t.exec(c, "CREATE TABLE x(id, name, data)")
+	t.exec(c, "INSERT INTO x VALUES(1, 'alpha', NULL)")
+	t.exec(c, "INSERT INTO x VALUES(22, 'a|b\nc', x'0102')")
+	t.exec(c, "INSERT INTO x VALUES(333, 'gamma-delta', 'text')")
+
+	tests := []struct {
+		f    Formatter
+		want string
+	}{{
+		Formatter{Null: "NULL", MaxRows: 2},
+		"id  name   data\n" +
+			"--  -----  -------\n" +
+			" 1  alpha  NULL\n" +
+			"22  a|b c  X'0102'\n",
+	}, {
+		Formatter{Mode: FormatBox, MaxWidth: 5, NoHeader: true},
+		"┌─────┬───────┬───────┐\n" +
+			"│   1 │ alpha │       │\n" +
+			"│  22 │ a|b c │ X'01… │\n" +
+			"│ 333 │ gamm… │ text  │\n" +
+			"└─────┴───────┴───────┘\n",
+	}, {
+		Formatter{Mode: FormatMarkdown, MaxRows: 2},
+		"| id  | name  | data    |\n" +
+			"| --: | ----- | ------- |\n" +
+			"|   1 | alpha |         |\n" +
+			"|  22 | a\\|b c | X'0102' |\n",
+	}, {
+		Formatter{Mode: FormatCSV, Null: `\N`, MaxRows: 2},
+		"id,name,data\n1,alpha,\\N\n22,\"a|b\nc\",\x01\x02\n",
+	}}
+	for i, test := range tests {
+		var buf bytes.Buffer
+		s := t.prepare(c, "SELECT * FROM x ORDER BY id")
+		if err := test.f.Format(&buf, s); err != nil {
+			t.Fatalf("%d: f.Format() unexpected error: %v", i, err)
+		}
+		t.close(s)
+		if buf.String() != test.want {
+			t.Fatalf("%d: f.Format() expected\n%s\ngot\n%s", i, test.want, buf.String())
+		}
+	}
+}
+
+func TestConnSchema(T *testing.T) {
+	t := begin(T)
+
+	c := t.open(":memory:")
+	defer t.close(c)
+	t.exec(c, `
+		CREATE TABLE p(id INTEGER PRIMARY KEY AUTOINCREMENT, name TEXT COLLATE NOCASE NOT NULL DEFAULT 'x');
+		CREATE TABLE c(a, b, pid REFERENCES p ON DELETE CASCADE, PRIMARY KEY(b, a), UNIQUE(pid));
+		CREATE INDEX c_ab ON c(a, b);
+		CREATE VIEW v AS SELECT id, name FROM p;
+		CREATE TRIGGER t AFTER INSERT ON c BEGIN SELECT 1; END;
+	`)
+	s, err := c.Schema("")
+	if err != nil {
+		t.Fatalf("c.Schema() unexpected error: %v", err)
+	}
+	if len(s.Tables) != 3 || len(s.Views) != 1 || len(s.Triggers) != 1 ||
+		len(s.Indexes) != 3 {
+		t.Fatalf("c.Schema() unexpected object counts: %d %d %d %d",
+			len(s.Tables), len(s.Views), len(s.Triggers), len(s.Indexes))
+	}
+
+	p := s.Tables[0]
+	if p.Name != "p" || !p.AutoIncrement || !reflect.DeepEqual(p.PrimaryKey, []string{"id"}) {
+		t.Fatalf("p = %+v", p)
+	}
+	want := &Column{Name: "name", Type: "TEXT", Collation: "NOCASE",
+		Default: "'x'", NotNull: true}
+	if have := p.Column("NAME"); !reflect.DeepEqual(have, want) {
+		t.Fatalf("p.Column() expected %+v; got %+v", want, have)
+	}
+	if col := p.Columns[0]; col.PrimaryKey != 1 || !col.AutoIncrement ||
+		col.Collation != "BINARY" {
+		t.Fatalf("p.Columns[0] = %+v", col)
+	}
+	if s.Tables[1].Name != "sqlite_sequence" {
+		t.Fatalf("s.Tables[1].Name = %q", s.Tables[1].Name)
+	}
+
+	ct := s.Tables[2]
+	if !reflect.DeepEqual(ct.PrimaryKey, []string{"b", "a"}) || ct.AutoIncrement {
+		t.Fatalf("c = %+v", ct)
+	}
+	wantFK := []*ForeignKey{{Table: "p", From: []string{"pid"}, To: []string{""},
+		OnUpdate: "NO ACTION", OnDelete: "CASCADE", Match: "NONE"}}
+	if !reflect.DeepEqual(ct.ForeignKeys, wantFK) {
+		t.Fatalf("c.ForeignKeys expected %+v; got %+v", wantFK[0], ct.ForeignKeys)
+	}
+	if len(ct.Indexes) != 3 || len(ct.Triggers) != 1 || ct.Triggers[0].Name != "t" {
+		t.Fatalf("c = %+v", ct)
+	}
+	for _, idx := range ct.Indexes {
+		var want *Index
+		switch {
+		case idx.Name == "c_ab":
+			want = &Index{"c_ab", "c", idx.SQL, false, []string{"a", "b"}}
+		case idx.SQL == "" && len(idx.Columns) == 2:
+			want = &Index{idx.Name, "c", "", true, []string{"b", "a"}}
+		default:
+			want = &Index{idx.Name, "c", "", true, []string{"pid"}}
+		}
+		if !reflect.DeepEqual(idx, want) {
+			t.Fatalf("index expected %+v; got %+v", want, idx)
+		}
+	}
+
+	v := s.Views[0]
+	if len(v.Columns) != 2 || v.Columns[1].Name != "name" || v.Columns[1].Type != "TEXT" ||
+		v.Err != nil {
+		t.Fatalf("v = %+v", v)
+	}
+
+	// A broken view does not prevent other objects from being described
+	t.exec(c, "CREATE TABLE d(x); CREATE VIEW w AS SELECT x FROM d; DROP TABLE d;")
+	if s, err = c.Schema(""); err != nil {
+		t.Fatalf("c.Schema() unexpected error: %v", err)
+	}
+	if len(s.Views) != 2 || s.Views[0].Err != nil || s.Views[1].Err == nil ||
+		len(s.Views[1].Columns) != 0 {
+		t.Fatalf("s.Views = %+v", s.Views)
+	}
+	for _, tbl := range s.Tables {
+		if tbl.Err != nil {
+			t.Fatalf("table %s unexpected error: %v", tbl.Name, tbl.Err)
+		}
+	}
+}
+
+func TestLoadExtension(T *testing.T) {
+	t := begin(T)
+
+	c := t.open(":memory:")
+	defer t.close(c)
+	if err := c.EnableLoadExtension(true); err == ErrNoLoadExtension {
+		if err = c.LoadExtension("ext", ""); err != ErrNoLoadExtension {
+			t.Fatalf("c.LoadExtension() expected ErrNoLoadExtension; got %v", err)
+		}
+		t.Skip("extension loading is disabled")
+	} else if err != nil {
+		t.Fatalf("c.EnableLoadExtension() unexpected error: %v", err)
+	}
+	path := "go-sqlite-missing-ext"
+	if err := c.LoadExtension(path, "entry"); err == nil {
+		t.Fatalf("c.LoadExtension() expected an error")
+	} else if !strings.Contains(err.Error(), "go-sqlite-missing-ext") {
+		t.Fatalf("c.LoadExtension() expected file name in error; got %v", err)
+	}
+	if err := c.EnableLoadExtension(false); err != nil {
+		t.Fatalf("c.EnableLoadExtension() unexpected error: %v", err)
+	}
+	if err := c.LoadExtension(path, ""); err == nil {
+		t.Fatalf("c.LoadExtension() expected an error")
+	}
+	t.errCode(AutoExtension(nil), MISUSE)
+	if err := ResetAutoExtension(); err != nil {
+		t.Fatalf("ResetAutoExtension() unexpected error: %v", err)
+	}
+}
+
+// hookEnabled controls the connect hook registered by TestConnectHook.
+var hookEnabled bool
+
+func TestConnectHook(T *testing.T) {
+	t := begin(T)
+
+	RegisterConnectHook(func(c *Conn) error {
+		if hookEnabled {
+			return c.Exec("PRAGMA user_version=1")
+		}
+		return nil
+	})
+	hookEnabled = true
+	defer func() { hookEnabled = false }()
+
+	c := t.open(":memory:")
+	s := t.query(c, "PRAGMA user_version")
+	var v int
+	t.scan(s, &v)
+	t.close(s)
+	t.close(c)
+	if v != 1 {
+		t.Fatalf("user_version expected 1; got %d", v)
+	}
+
+	// Driver hooks run after package hooks
+	RegisterDriver("sqlite3-hook-test", func(c *Conn) error {
+		s, err := c.Query("PRAGMA user_version")
+		if err != nil {
+			return err
+		}
+		defer s.Close()
+		if err = s.Scan(&v); err == nil && v != 1 {
+			err = NewError(ERROR, "package hook was not called")
+		}
+		if err != nil {
+			return err
+		}
+		return c.Exec("PRAGMA user_version=2")
+	})
+	db, err := sql.Open("sqlite3-hook-test", ":memory:")
+	if err != nil {
+		t.Fatalf("sql.Open() unexpected error: %v", err)
+	}
+	defer db.Close()
+	if err = db.QueryRow("PRAGMA user_version").Scan(&v); err != nil {
+		t.Fatalf("db.QueryRow() unexpected error: %v", err)
+	} else if v != 2 {
+		t.Fatalf("user_version expected 2; got %d", v)
+	}
+
+	// Errors are returned by Open
+	hookErr := NewError(ERROR, "hook failed")
+	RegisterDriver("sqlite3-hook-error", func(*Conn) error { return hookErr })
+	db2, err := sql.Open("sqlite3-hook-error", ":memory:")
+	if err != nil {
+		t.Fatalf("sql.Open() unexpected error: %v", err)
+	}
+	defer db2.Close()
+	if err = db2.Ping(); err != hookErr {
+		t.Fatalf("db2.Ping() expected %v; got %v", hookErr, err)
+	}
+}
+
+func TestErrorIs(T *testing.T) {
+	t := begin(T)
+
+	c := t.open(":memory:")
+	defer t.close(c)
+	t.exec(c, "CREATE TABLE x(a UNIQUE, b NOT NULL)")
+	t.exec(c, "INSERT INTO x VALUES(1, 1)")
+
+	sql := "INSERT INTO x VALUES(1, 2)"
+	err := c.Exec(sql)
+	for _, target := range []error{ErrConstraint, ErrConstraintUnique} {
+		if !errors.Is(err, target) {
+			t.Fatalf("errors.Is(%v, %v) expected true", err, target)
+		}
+	}
+	for _, target := range []error{ErrBusy, ErrConstraintNotNull, ErrBadConn} {
+		if errors.Is(err, target) {
+			t.Fatalf("errors.Is(%v, %v) expected false", err, target)
+		}
+	}
+	var e *Error
+	if !errors.As(fmt.Errorf("wrapped: %w", err), &e) {
+		t.Fatalf("errors.As() expected true")
+	}
+	if e.PrimaryCode() != CONSTRAINT || e.ExtendedCode() != CONSTRAINT_UNIQUE {
+		t.Fatalf("unexpected codes: %d %d", e.PrimaryCode(), e.ExtendedCode())
+	}
+	if e.SQL() != sql || e.Offset() != -1 || e.Unwrap() != nil {
+		t.Fatalf("unexpected error details: %q %d %v", e.SQL(), e.Offset(), e.Unwrap())
+	}
+
+	// Prepare errors
+	sql = "SELECT * FROM x WHERE c=1"
+	_, err = c.Prepare(sql)
+	if !errors.As(err, &e) || e.SQL() != sql || !errors.Is(err, ErrError) {
+		t.Fatalf("c.Prepare() unexpected error: %v", err)
+	}
+	if off := e.Offset(); off != -1 && off != strings.Index(sql, "c=1") {
+		t.Fatalf("e.Offset() unexpected value: %d", off)
+	}
+
+	// Package errors
+	if !errors.Is(ErrBadConn, ErrMisuse) || errors.Is(ErrBadConn, ErrBadStmt) {
+		t.Fatalf("errors.Is() unexpected result for ErrBadConn")
+	}
+	if !errors.Is(ErrBadConn, ErrBadConn) || errors.Is(ErrMisuse, ErrBadConn) {
+		t.Fatalf("errors.Is() unexpected result for ErrMisuse")
+	}
+}
+
+func TestConstraint(T *testing.T) {
+	t := begin(T)
+
+	c := t.open(":memory:")
+	defer t.close(c)
+	t.exec(c, `
+		CREATE TABLE p(id INTEGER PRIMARY KEY);
+		CREATE TABLE x(id INTEGER PRIMARY KEY, a NOT NULL, b, c,
+			CONSTRAINT positive CHECK(a>0), UNIQUE(b, c));
+		CREATE TABLE y(id INTEGER PRIMARY KEY, pid REFERENCES p, qid REFERENCES p);
+		INSERT INTO p VALUES(1);
+		INSERT INTO x VALUES(1, 1, 1, 1);
+	`)
+	tests := []struct {
+		sql  string
+		want *Constraint
+	}{
+		{"INSERT INTO x VALUES(1, 2, 2, 2)",
+			&Constraint{CONSTRAINT_PRIMARYKEY, "x", []string{"id"}, ""}},
+		{"INSERT INTO x VALUES(2, NULL, 2, 2)",
+			&Constraint{CONSTRAINT_NOTNULL, "x", []string{"a"}, ""}},
+		{"INSERT INTO x VALUES(2, 0, 2, 2)",
+			&Constraint{CONSTRAINT_CHECK, "", nil, "positive"}},
+		{"INSERT INTO x VALUES(2, 2, 1, 1)",
+			&Constraint{CONSTRAINT_UNIQUE, "x", []string{"b", "c"}, ""}},
+	}
+	for _, test := range tests {
+		var e *Error
+		if err := c.Exec(test.sql); !errors.As(err, &e) {
+			t.Fatalf("c.Exec(%q) expected *Error; got %v", test.sql, err)
+		}
+		if have := e.Constraint(); !reflect.DeepEqual(have, test.want) {
+			t.Fatalf("e.Constraint() expected %+v; got %+v", test.want, have)
+		}
+	}
+	if ErrBadConn.Constraint() != nil {
+		t.Fatalf("ErrBadConn.Constraint() expected nil")
+	}
+
+	// Foreign key check
+	t.exec(c, "INSERT INTO y VALUES(1, 1, 1); INSERT INTO y VALUES(2, 1, 2)")
+	t.exec(c, "INSERT INTO y VALUES(3, 3, 1)")
+	vs, err := c.ForeignKeyCheck("", "y")
+	if err != nil {
+		t.Fatalf("c.ForeignKeyCheck() unexpected error: %v", err)
+	}
+	if len(vs) != 2 {
+		t.Fatalf("c.ForeignKeyCheck() expected 2 violations; got %d", len(vs))
+	}
+	for _, v := range vs {
+		col := "qid"
+		if v.RowID == 3 {
+			col = "pid"
+		}
+		if v.Table != "y" || v.Parent != "p" || v.Key == nil ||
+			!reflect.DeepEqual(v.Key.From, []string{col}) {
+			t.Fatalf("unexpected violation: %+v (%+v)", v, v.Key)
+		}
+	}
+	if vs, err = c.ForeignKeyCheck("main", "x"); err != nil || len(vs) != 0 {
+		t.Fatalf("c.ForeignKeyCheck() expected no violations; got %v (%v)", vs, err)
+	}
+}
+
+func TestLogger(T *testing.T) {
+	t := begin(T)
+
+	var codes []int
+	var msgs []string
+	SetLogger(func(code int, msg string) {
+		codes = append(codes, code)
+		msgs = append(msgs, msg)
+	})
+	defer SetLogger(nil)
+
+	c := t.open(":memory:")
+	defer t.close(c)
+	t.exec(c, "CREATE TABLE a(x); CREATE TABLE b(y);")
+	t.exec(c, "SELECT * FROM a, b WHERE x=y")
+
+	found := false
+	for i, code := range codes {
+		if code == WARNING_AUTOINDEX && strings.Contains(msgs[i], "automatic index") {
+			found = true
+		}
+	}
+	if !found {
+		t.Fatalf("expected WARNING_AUTOINDEX log message; got %v %q", codes, msgs)
+	}
+
+	SetLogger(nil)
+	codes, msgs = nil, nil
+	t.exec(c, "SELECT * FROM b, a WHERE y=x")
+	if len(codes) != 0 {
+		t.Fatalf("expected no log messages; got %v %q", codes, msgs)
+	}
+}
+
+func TestConfig(T *testing.T) {
+	t := begin(T)
+
+	// Shutdown requires all connections to be closed, which cannot be ensured
+	// after other tests have run, so the test is run in a new process.
+	if os.Getenv("GO_SQLITE_TEST_CONFIG") != "1" {
+		cmd := exec.Command(os.Args[0], "-test.run=^TestConfig$")
+		cmd.Env = append(os.Environ(), "GO_SQLITE_TEST_CONFIG=1")
+		if out, err := cmd.CombinedOutput(); err != nil {
+			t.Fatalf("TestConfig subprocess failed: %v\n%s", err, out)
+		}
+		return
+	}
+
+	if err := Initialize(); err != nil {
+		t.Fatalf("Initialize() unexpected error: %v", err)
+	}
+	t.errCode(Config(CONFIG_MEMSTATUS, 0), MISUSE)
+
+	c := t.open(":memory:")
+	t.errCode(Shutdown(), BUSY)
+	t.close(c)
+	if err := Shutdown(); err != nil {
+		t.Fatalf("Shutdown() unexpected error: %v", err)
+	}
+	defer func() {
+		Shutdown()
+		Config(CONFIG_MEMSTATUS, 1)
+	}()
+
+	t.errCode(Config(-1), MISUSE)
+	t.errCode(Config(CONFIG_LOOKASIDE, 1), MISUSE)
+	if err := Config(CONFIG_MEMSTATUS, 0); err != nil {
+		t.Fatalf("Config(CONFIG_MEMSTATUS) unexpected error: %v", err)
+	}
+	if err := Config(CONFIG_PAGECACHE, 0, 0); err != nil {
+		t.Fatalf("Config(CONFIG_PAGECACHE) unexpected error: %v", err)
+	}
+
+	// Lazy initialization
+	c = t.open(":memory:")
+	defer t.close(c)
+	before, _, err := Status(STATUS_MEMORY_USED, false)
+	if err != nil {
+		t.Fatalf("Status() unexpected error: %v", err)
+	}
+	t.exec(c, "CREATE TABLE x(a); INSERT INTO x VALUES(randomblob(100000))")
+	if after, _, _ := Status(STATUS_MEMORY_USED, false); after != before {
+		t.Fatalf("Status() expected %d with memstatus disabled; got %d", before, after)
+	}
+	t.errCode(Config(CONFIG_MEMSTATUS, 1), MISUSE)
+}
+
+func TestStats(T *testing.T) {
+	t := begin(T)
+
+	s1, err := ReadStats()
+	if err != nil {
+		t.Fatalf("ReadStats() unexpected error: %v", err)
+	}
+	c := t.open(":memory:")
+	defer t.close(c)
+	t.exec(c, "CREATE TABLE x(a); INSERT INTO x VALUES(randomblob(100000))")
+	s2, err := ReadStats()
+	if err != nil {
+		t.Fatalf("ReadStats() unexpected error: %v", err)
+	}
+	if s2.Conns != s1.Conns+1 {
+		t.Fatalf("s2.Conns expected %d; got %d", s1.Conns+1, s2.Conns)
+	}
+	if s2.MemoryUsed.Cur <= s1.MemoryUsed.Cur {
+		t.Fatalf("s2.MemoryUsed expected > %d; got %d", s1.MemoryUsed.Cur, s2.MemoryUsed.Cur)
+	}
+	if s2.ConnStats != nil || strings.Contains(s2.String(), "ConnStats") {
+		t.Fatalf("s2.ConnStats expected nil before AddConn; got %v", s2)
+	}
+	for _, v := range s2.Metrics() {
+		if strings.HasPrefix(v.Name, "/sqlite3/cache/") {
+			t.Fatalf("s2.Metrics() unexpected %s before AddConn", v.Name)
+		}
+	}
+	if err = s2.AddConn(c); err != nil {
+		t.Fatalf("s2.AddConn() unexpected error: %v", err)
+	}
+	if cs := s2.ConnStats; cs == nil || cs.CacheUsed.Cur <= 0 || cs.SchemaUsed.Cur <= 0 {
+		t.Fatalf("connection counters were not added: %v", s2)
+	}
+
+	m := make(map[string]int64)
+	for _, v := range s2.Metrics() {
+		m[v.Name] = v.Value
+	}
+	if v := m["/sqlite3/memory/used:bytes"]; v != s2.MemoryUsed.Cur {
+		t.Fatalf("memory/used metric expected %d; got %d", s2.MemoryUsed.Cur, v)
+	}
+	if _, ok := m["/sqlite3/cache/hits:pages"]; !ok {
+		t.Fatalf("Metrics() missing cache/hits")
+	}
+	var v struct{ Conns int }
+	if err = json.Unmarshal([]byte(s2.String()), &v); err != nil || v.Conns != s2.Conns {
+		t.Fatalf("s2.String() unexpected result: %s (%v)", s2, err)
+	}
+
+	// Hard heap limit
+	if VersionNum() >= 3031000 {
+		if prev, err := HardHeapLimit(1 << 30); prev != 0 || err != nil {
+			t.Fatalf("HardHeapLimit() expected 0; got %d (%v)", prev, err)
+		}
+		if prev, err := HardHeapLimit(0); prev != 1<<30 || err != nil {
+			t.Fatalf("HardHeapLimit() expected %d; got %d (%v)", 1<<30, prev, err)
+		}
+	} else {
+		if _, err := HardHeapLimit(-1); err == nil {
+			t.Fatalf("HardHeapLimit() expected an error")
+		}
+		if s2.HardHeapLimit != -1 {
+			t.Fatalf("s2.HardHeapLimit expected -1; got %d", s2.HardHeapLimit)
+		}
+	}
+}
+
+type testInstrument struct {
+	begin int
+	runs  []*StmtRun
+}
+
+func (i *testInstrument) Begin(s *Stmt)           { i.begin++ }
+func (i *testInstrument) End(s *Stmt, r *StmtRun) { i.runs = append(i.runs, r) }
+
+func TestInstrument(T *testing.T) {
+	t := begin(T)
+	c := t.open(":memory:")
+	defer t.close(c)
+
+	ti := new(testInstrument)
+	if prev := c.Instrument(ti); prev != nil {
+		t.Fatalf("c.Instrument() expected nil; got %v", prev)
+	}
+	t.exec(c, "CREATE TABLE x(a); INSERT INTO x VALUES(1); INSERT INTO x VALUES(2)")
+	if ti.begin != 3 || len(ti.runs) != 3 {
+		t.Fatalf("expected 3 runs; got %d/%d", ti.begin, len(ti.runs))
+	}
+
+	// Full scan with rows consumed by Next
+	s := t.query(c, "SELECT a FROM x WHERE a > 0 ORDER BY a")
+	for s.Next() == nil {
+	}
+	t.close(s)
+	r := ti.runs[len(ti.runs)-1]
+	if r.Rows != 2 || r.FullScan == 0 || r.Sort != 1 || r.VMStep == 0 || r.Err != nil {
+		t.Fatalf("unexpected run statistics: %+v", r)
+	}
+
+	// Partial run ended by Close
+	s = t.query(c, "SELECT a FROM x")
+	t.close(s)
+	if r = ti.runs[len(ti.runs)-1]; r.Rows != 1 || r.SQL != "SELECT a FROM x" {
+		t.Fatalf("unexpected run statistics: %+v", r)
+	}
+
+	// Counters reset during the second run
+	s = t.prepare(c, "SELECT a FROM x")
+	for i := 0; i < 2; i++ {
+		if err := s.Query(); err != nil {
+			t.Fatalf("s.Query() unexpected error: %v", err)
+		}
+		if i == 1 {
+			s.Status(STMTSTATUS_FULLSCAN_STEP, true)
+			s.Status(STMTSTATUS_VM_STEP, true)
+		}
+		for s.Next() == nil {
+		}
+	}
+	t.close(s)
+	if r = ti.runs[len(ti.runs)-1]; r.FullScan < 0 || r.VMStep <= 0 {
+		t.Fatalf("unexpected run statistics: %+v", r)
+	}
+
+	// Collector
+	col := NewCollector(3)
+	if prev := c.Instrument(col); prev != ti {
+		t.Fatalf("c.Instrument() expected %v; got %v", ti, prev)
+	}
+	t.exec(c, "CREATE INDEX xa ON x(a)")
+	for i := 0; i < 3; i++ {
+		t.close(t.query(c, "SELECT * FROM x WHERE a = ?", i%2+1))
+		s = t.query(c, "SELECT * FROM x WHERE a+0 IN (1, 2, "+strconv.Itoa(i)+")")
+		for s.Next() == nil {
+		}
+		t.close(s)
+	}
+	t.exec(c, "SELECT 1")
+	qs, dropped := col.Queries()
+	if len(qs) != 3 || dropped != 1 {
+		t.Fatalf("col.Queries() expected 3 queries and 1 dropped run; got %d, %d",
+			len(qs), dropped)
+	}
+	fs := col.FullScans(0)
+	if len(fs) != 1 || fs[0].SQL != "SELECT * FROM x WHERE a + ? IN (?)" ||
+		fs[0].Count != 3 || fs[0].Rows != 6 {
+		t.Fatalf("col.FullScans() unexpected result: %+v", fs)
+	}
+	if n := len(col.Slowest(1)); n != 1 {
+		t.Fatalf("col.Slowest(1) expected 1 query; got %d", n)
+	}
+	col.Reset()
+	if qs, _ = col.Queries(); len(qs) != 0 {
+		t.Fatalf("col.Queries() expected no queries after Reset; got %d", len(qs))
+	}
+}
+
+func TestNormalizeSQL(T *testing.T) {
+	t := begin(T)
+	tests := []struct{ in, out string }{
+		{"SELECT 1", "SELECT ?"},
+		{"select *  from t where a='x''y' and b=X'00' -- c\n;", "SELECT * FROM t WHERE a = ? AND b = ?"},
+		{"SELECT a,b FROM \"t 1\" WHERE c IN (1,2 , 3.5e-3)", "SELECT a, b FROM \"t 1\" WHERE c IN (?)"},
+		{"INSERT INTO t VALUES(:a, @b, $c, ?1, ?)", "INSERT INTO t VALUES (?)"},
+		{"SELECT x.a/*c*/FROM x WHERE a>=-1 OR b<>2", "SELECT x.a FROM x WHERE a >= ? OR b <> ?"},
+		{"select -1, +2, a-3, a - -4, (b)+5 from t limit -1", "SELECT ?, a - ?, a - ?, (b) + ? FROM t LIMIT ?"},
+		{"SELECT * FROM t WHERE c IN (-1, 2, -3)", "SELECT * FROM t WHERE c IN (?)"},
+		{"SELECT [a b], t2.c1 FROM t2", "SELECT [a b], t2.c1 FROM t2"},
+	}
+	for _, test := range tests {
+		if out := NormalizeSQL(test.in); out != test.out {
+			t.Errorf("NormalizeSQL(%q) expected %q; got %q", test.in, test.out, out)
+		}
+	}
+}
+
+func TestZombie(T *testing.T) {
+	t := begin(T)
+	conns := func() int {
+		s, err := ReadStats()
+		if err != nil {
+			t.Fatalf(cl("ReadStats() unexpected error: %v"), err)
+		}
+		return s.Conns
+	}
+
+	n := conns()
+	c := t.open(":memory:")
+	s := t.prepare(c, "SELECT 1")
+	t.errCode(c.Close(), BUSY)
+	if have := conns(); have != n+1 {
+		t.Fatalf("Conns expected %d for a zombie connection; got %d", n+1, have)
+	}
+	t.close(s)
+	if have := conns(); have != n {
+		t.Fatalf("Conns expected %d after the last statement was closed; got %d", n, have)
+	}
+}
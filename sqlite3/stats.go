@@ -0,0 +1,215 @@
+// Copyright 2013 The Go-SQLite Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package sqlite3
+
+/*
+#include "sqlite3.h"
+*/
+import "C"
+
+import "encoding/json"
+
+// Counter contains the current and peak values of a performance counter. Some
+// counters only report one of the two values (e.g. DBSTATUS_CACHE_HIT is
+// reported as Cur and DBSTATUS_LOOKASIDE_HIT as Peak).
+type Counter struct {
+	Cur  int64
+	Peak int64
+}
+
+// Stats is a snapshot of SQLite performance counters. ReadStats returns the
+// global counters, and Stats.AddConn adds the counters of individual
+// connections.
+type Stats struct {
+	Conns         int   // Number of open connections
+	SoftHeapLimit int64 // Current soft heap limit (0 = none)
+	HardHeapLimit int64 // Current hard heap limit (0 = none, -1 = unsupported)
+
+	// Global counters (see Status)
+	MemoryUsed        Counter // STATUS_MEMORY_USED
+	PageCacheUsed     Counter // STATUS_PAGECACHE_USED
+	PageCacheOverflow Counter // STATUS_PAGECACHE_OVERFLOW
+	ScratchUsed       Counter // STATUS_SCRATCH_USED
+	ScratchOverflow   Counter // STATUS_SCRATCH_OVERFLOW
+	MallocSize        Counter // STATUS_MALLOC_SIZE
+	ParserStack       Counter // STATUS_PARSER_STACK
+	PageCacheSize     Counter // STATUS_PAGECACHE_SIZE
+	ScratchSize       Counter // STATUS_SCRATCH_SIZE
+	MallocCount       Counter // STATUS_MALLOC_COUNT
+
+	// Connection counters summed over all connections passed to AddConn, or
+	// nil if AddConn was not called.
+	ConnStats *ConnStats `json:",omitempty"`
+}
+
+// ConnStats contains the performance counters of one or more connections (see
+// Conn.Status).
+type ConnStats struct {
+	LookasideUsed     Counter // DBSTATUS_LOOKASIDE_USED
+	CacheUsed         Counter // DBSTATUS_CACHE_USED
+	SchemaUsed        Counter // DBSTATUS_SCHEMA_USED
+	StmtUsed          Counter // DBSTATUS_STMT_USED
+	LookasideHit      Counter // DBSTATUS_LOOKASIDE_HIT
+	LookasideMissSize Counter // DBSTATUS_LOOKASIDE_MISS_SIZE
+	LookasideMissFull Counter // DBSTATUS_LOOKASIDE_MISS_FULL
+	CacheHit          Counter // DBSTATUS_CACHE_HIT
+	CacheMiss         Counter // DBSTATUS_CACHE_MISS
+	CacheWrite        Counter // DBSTATUS_CACHE_WRITE
+	DeferredFKs       Counter // DBSTATUS_DEFERRED_FKS
+}
+
+// statusCounters maps STATUS constants to Stats fields.
+var statusCounters = []struct {
+	op int
+	fn func(s *Stats) *Counter
+}{
+	{STATUS_MEMORY_USED, func(s *Stats) *Counter { return &s.MemoryUsed }},
+	{STATUS_PAGECACHE_USED, func(s *Stats) *Counter { return &s.PageCacheUsed }},
+	{STATUS_PAGECACHE_OVERFLOW, func(s *Stats) *Counter { return &s.PageCacheOverflow }},
+	{STATUS_SCRATCH_USED, func(s *Stats) *Counter { return &s.ScratchUsed }},
+	{STATUS_SCRATCH_OVERFLOW, func(s *Stats) *Counter { return &s.ScratchOverflow }},
+	{STATUS_MALLOC_SIZE, func(s *Stats) *Counter { return &s.MallocSize }},
+	{STATUS_PARSER_STACK, func(s *Stats) *Counter { return &s.ParserStack }},
+	{STATUS_PAGECACHE_SIZE, func(s *Stats) *Counter { return &s.PageCacheSize }},
+	{STATUS_SCRATCH_SIZE, func(s *Stats) *Counter { return &s.ScratchSize }},
+	{STATUS_MALLOC_COUNT, func(s *Stats) *Counter { return &s.MallocCount }},
+}
+
+// dbStatusCounters maps DBSTATUS constants to ConnStats fields.
+var dbStatusCounters = []struct {
+	op int
+	fn func(s *ConnStats) *Counter
+}{
+	{DBSTATUS_LOOKASIDE_USED, func(s *ConnStats) *Counter { return &s.LookasideUsed }},
+	{DBSTATUS_CACHE_USED, func(s *ConnStats) *Counter { return &s.CacheUsed }},
+	{DBSTATUS_SCHEMA_USED, func(s *ConnStats) *Counter { return &s.SchemaUsed }},
+	{DBSTATUS_STMT_USED, func(s *ConnStats) *Counter { return &s.StmtUsed }},
+	{DBSTATUS_LOOKASIDE_HIT, func(s *ConnStats) *Counter { return &s.LookasideHit }},
+	{DBSTATUS_LOOKASIDE_MISS_SIZE, func(s *ConnStats) *Counter { return &s.LookasideMissSize }},
+	{DBSTATUS_LOOKASIDE_MISS_FULL, func(s *ConnStats) *Counter { return &s.LookasideMissFull }},
+	{DBSTATUS_CACHE_HIT, func(s *ConnStats) *Counter { return &s.CacheHit }},
+	{DBSTATUS_CACHE_MISS, func(s *ConnStats) *Counter { return &s.CacheMiss }},
+	{DBSTATUS_CACHE_WRITE, func(s *ConnStats) *Counter { return &s.CacheWrite }},
+	{DBSTATUS_DEFERRED_FKS, func(s *ConnStats) *Counter { return &s.DeferredFKs }},
+}
+
+// ReadStats returns a snapshot of the global performance counters and the
+// number of open connections. ConnStats is nil until AddConn is called. Peak
+// values are not reset.
+func ReadStats() (*Stats, error) {
+	if err := Initialize(); err != nil {
+		return nil, err
+	}
+	s := new(Stats)
+	s.SoftHeapLimit = SoftHeapLimit(-1)
+	s.HardHeapLimit = -1
+	if n, err := HardHeapLimit(-1); err == nil {
+		s.HardHeapLimit = n
+	}
+	var cur, peak C.int
+	for _, c := range statusCounters {
+		if C.sqlite3_status(C.int(c.op), &cur, &peak, 0) == OK {
+			*c.fn(s) = Counter{int64(cur), int64(peak)}
+		}
+	}
+	initMu.Lock()
+	s.Conns = openConns
+	initMu.Unlock()
+	return s, nil
+}
+
+// AddConn adds the performance counters of connection c to s.ConnStats, which
+// is allocated by the first call. Like all other Conn methods, it must not be
+// called while c is being used by another goroutine, because SQLite does not
+// synchronize access to the connection in the default multi-thread mode. A
+// connection pool can aggregate the counters of idle connections, or each
+// connection can add its counters to a shared Stats under a mutex.
+func (s *Stats) AddConn(c *Conn) error {
+	if c.db == nil {
+		return ErrBadConn
+	}
+	if s.ConnStats == nil {
+		s.ConnStats = new(ConnStats)
+	}
+	var cur, peak C.int
+	for _, st := range dbStatusCounters {
+		if C.sqlite3_db_status(c.db, C.int(st.op), &cur, &peak, 0) == OK {
+			p := st.fn(s.ConnStats)
+			p.Cur += int64(cur)
+			p.Peak += int64(peak)
+		}
+	}
+	return nil
+}
+
+// Metric is a named value returned by Stats.Metrics.
+type Metric struct {
+	Name  string
+	Value int64
+}
+
+// Metrics returns the values in s as a list of metrics named in the style of
+// the runtime/metrics package (e.g. "/sqlite3/memory/used:bytes"). Counters
+// that report both values are split into two metrics, with the peak value
+// using the "-peak" suffix. Connection metrics are only included if ConnStats
+// is not nil.
+func (s *Stats) Metrics() []Metric {
+	m := []Metric{
+		{"/sqlite3/conns:connections", int64(s.Conns)},
+		{"/sqlite3/memory/soft-limit:bytes", s.SoftHeapLimit},
+		{"/sqlite3/memory/hard-limit:bytes", s.HardHeapLimit},
+	}
+	add := func(name, unit string, c Counter, cur, peak bool) {
+		if cur {
+			m = append(m, Metric{"/sqlite3/" + name + ":" + unit, c.Cur})
+		}
+		if peak {
+			m = append(m, Metric{"/sqlite3/" + name + "-peak:" + unit, c.Peak})
+		}
+	}
+	add("memory/used", "bytes", s.MemoryUsed, true, true)
+	add("memory/allocs", "objects", s.MallocCount, true, true)
+	add("memory/largest-alloc", "bytes", s.MallocSize, false, true)
+	add("pagecache/used", "pages", s.PageCacheUsed, true, true)
+	add("pagecache/overflow", "bytes", s.PageCacheOverflow, true, true)
+	add("pagecache/largest-alloc", "bytes", s.PageCacheSize, false, true)
+	add("scratch/used", "buffers", s.ScratchUsed, true, true)
+	add("scratch/overflow", "bytes", s.ScratchOverflow, true, true)
+	add("scratch/largest-alloc", "bytes", s.ScratchSize, false, true)
+	add("parser/stack-depth", "entries", s.ParserStack, false, true)
+	if cs := s.ConnStats; cs != nil {
+		add("lookaside/used", "slots", cs.LookasideUsed, true, true)
+		add("lookaside/hits", "allocs", cs.LookasideHit, false, true)
+		add("lookaside/misses-size", "allocs", cs.LookasideMissSize, false, true)
+		add("lookaside/misses-full", "allocs", cs.LookasideMissFull, false, true)
+		add("cache/used", "bytes", cs.CacheUsed, true, false)
+		add("cache/hits", "pages", cs.CacheHit, true, false)
+		add("cache/misses", "pages", cs.CacheMiss, true, false)
+		add("cache/writes", "pages", cs.CacheWrite, true, false)
+		add("schema/used", "bytes", cs.SchemaUsed, true, false)
+		add("stmt/used", "bytes", cs.StmtUsed, true, false)
+		add("deferred-fks", "connections", cs.DeferredFKs, true, false)
+	}
+	return m
+}
+
+// String returns the JSON encoding of s, which allows Stats to be used as an
+// expvar.Var.
+func (s *Stats) String() string {
+	b, _ := json.Marshal(s)
+	return string(b)
+}
+
+// ExpvarStats returns the current global Stats (see ReadStats) or nil if they
+// cannot be read. It allows the statistics to be published without importing
+// expvar in this package:
+//
+// 	expvar.Publish("sqlite3", expvar.Func(sqlite3.ExpvarStats))
+func ExpvarStats() interface{} {
+	if s, err := ReadStats(); err == nil {
+		return s
+	}
+	return nil
+}
@@ -6,13 +6,32 @@ package sqlite3
 
 /*
 #include "sqlite3.h"
+
+// Wrapper for sqlite3_error_offset, which is not available before 3.38.0.
+static int error_offset(sqlite3 *db) {
+#if SQLITE_VERSION_NUMBER >= 3038000
+	return sqlite3_error_offset(db);
+#else
+	return -1;
+#endif
+}
+
+// Wrapper for sqlite3_hard_heap_limit64, which is not available before 3.31.0.
+static sqlite3_int64 hard_heap_limit(sqlite3_int64 n) {
+#if SQLITE_VERSION_NUMBER >= 3031000
+	return sqlite3_hard_heap_limit64(n);
+#else
+	return -1;
+#endif
+}
 */
 import "C"
 
 import (
-	"bytes"
 	"fmt"
+	"os"
 	"reflect"
+	"strings"
 	"unsafe"
 )
 
@@ -98,34 +117,62 @@ type RollbackFunc func()
 type UpdateFunc func(op int, db, tbl RawString, row int64)
 
 // Error is returned for all SQLite API result codes other than OK, ROW, and
-// DONE.
+// DONE. Use errors.Is with the ErrBusy, ErrConstraint, etc. values to test the
+// result code.
 type Error struct {
-	rc  int
-	msg string
+	rc   int
+	msg  string
+	sql  string // SQL text that caused the error, if known
+	off  int    // Byte offset of the error in sql plus one (0 if unknown)
+	err  error  // Underlying error (e.g. returned by a codec)
+	code bool   // Flag indicating that Is should only compare result codes
 }
 
 // NewError creates a new Error instance using the specified SQLite result code
 // and error message.
 func NewError(rc int, msg string) *Error {
-	return &Error{rc, msg}
+	return &Error{rc: rc, msg: msg}
 }
 
 // libErr reports an error originating in SQLite. The error message is obtained
 // from the database connection when possible, which may include some additional
 // information. Otherwise, the result code is translated to a generic message.
+// NOMEM is replaced by the codec error that caused it, if there is one.
 func libErr(rc C.int, db *C.sqlite3) error {
+	if rc&0xff == NOMEM && db != nil {
+		if err := codecErr(db); err != nil {
+			return err
+		}
+	}
 	if db != nil && rc == C.sqlite3_errcode(db) {
-		return &Error{int(rc), C.GoString(C.sqlite3_errmsg(db))}
+		return &Error{rc: int(rc), msg: C.GoString(C.sqlite3_errmsg(db))}
 	}
-	return &Error{int(rc), C.GoString(C.sqlite3_errstr(rc))}
+	return &Error{rc: int(rc), msg: C.GoString(C.sqlite3_errstr(rc))}
+}
+
+// sqlErr adds the SQL text that caused err, which was returned by libErr, and
+// the error offset, if db is not nil and SQLite provides one.
+func sqlErr(err error, sql string, db *C.sqlite3) error {
+	e, ok := err.(*Error)
+	if !ok || e.sql != "" {
+		return err
+	}
+	cp := *e
+	cp.sql = sql
+	if db != nil {
+		if off := int(C.error_offset(db)); 0 <= off && off < len(sql) {
+			cp.off = off + 1
+		}
+	}
+	return &cp
 }
 
 // pkgErr reports an error originating in this package.
 func pkgErr(rc int, msg string, v ...interface{}) error {
 	if len(v) == 0 {
-		return &Error{rc, msg}
+		return &Error{rc: rc, msg: msg}
 	}
-	return &Error{rc, fmt.Sprintf(msg, v...)}
+	return &Error{rc: rc, msg: fmt.Sprintf(msg, v...)}
 }
 
 // Code returns the SQLite extended result code.
@@ -133,6 +180,51 @@ func (err *Error) Code() int {
 	return err.rc
 }
 
+// ExtendedCode returns the SQLite extended result code. It is the same as Code.
+func (err *Error) ExtendedCode() int {
+	return err.rc
+}
+
+// PrimaryCode returns the SQLite primary result code (the least significant 8
+// bits of the extended code).
+func (err *Error) PrimaryCode() int {
+	return err.rc & 0xff
+}
+
+// SQL returns the text of the statement that caused the error, or an empty
+// string if the error did not originate in a statement.
+func (err *Error) SQL() string {
+	return err.sql
+}
+
+// Offset returns the byte offset of the token in SQL() that caused the error,
+// or -1 if it is not known. Offsets are only reported by SQLite 3.38.0+.
+// [http://www.sqlite.org/c3ref/errcode.html]
+func (err *Error) Offset() int {
+	return err.off - 1
+}
+
+// Unwrap returns the underlying error, such as the error returned by a codec,
+// or nil if there isn't one.
+func (err *Error) Unwrap() error {
+	return err.err
+}
+
+// Is reports whether err matches target, which is one of the result code values
+// (ErrBusy, ErrConstraintUnique, etc.). Primary code values match all errors
+// with that primary code, and extended code values only match the same code.
+// Other errors, such as ErrBadConn, are only equal to themselves.
+func (err *Error) Is(target error) bool {
+	t, ok := target.(*Error)
+	if !ok || !t.code {
+		return false
+	}
+	if t.rc == t.rc&0xff {
+		return err.rc&0xff == t.rc
+	}
+	return err.rc == t.rc
+}
+
 // Error implements the error interface.
 func (err *Error) Error() string {
 	return fmt.Sprintf("sqlite3: %s [%d]", err.msg, err.rc)
@@ -140,17 +232,79 @@ func (err *Error) Error() string {
 
 // Errors returned for access attempts to closed or invalid objects.
 var (
-	ErrBadConn   = &Error{MISUSE, "closed or invalid connection"}
-	ErrBadStmt   = &Error{MISUSE, "closed or invalid statement"}
-	ErrBadIO     = &Error{MISUSE, "closed or invalid incremental I/O operation"}
-	ErrBadBackup = &Error{MISUSE, "closed or invalid backup operation"}
+	ErrBadConn   = &Error{rc: MISUSE, msg: "closed or invalid connection"}
+	ErrBadStmt   = &Error{rc: MISUSE, msg: "closed or invalid statement"}
+	ErrBadIO     = &Error{rc: MISUSE, msg: "closed or invalid incremental I/O operation"}
+	ErrBadBackup = &Error{rc: MISUSE, msg: "closed or invalid backup operation"}
 )
 
+// Result code values for use with errors.Is. Primary codes match all errors
+// with the same primary code (e.g. errors.Is(err, ErrConstraint) is true for
+// CONSTRAINT_UNIQUE errors), and extended codes only match the same extended
+// code.
+var (
+	ErrError      = codeErr(ERROR)
+	ErrInternal   = codeErr(INTERNAL)
+	ErrPerm       = codeErr(PERM)
+	ErrAbort      = codeErr(ABORT)
+	ErrBusy       = codeErr(BUSY)
+	ErrLocked     = codeErr(LOCKED)
+	ErrNoMem      = codeErr(NOMEM)
+	ErrReadOnly   = codeErr(READONLY)
+	ErrInterrupt  = codeErr(INTERRUPT)
+	ErrIOErr      = codeErr(IOERR)
+	ErrCorrupt    = codeErr(CORRUPT)
+	ErrNotFound   = codeErr(NOTFOUND)
+	ErrFull       = codeErr(FULL)
+	ErrCantOpen   = codeErr(CANTOPEN)
+	ErrProtocol   = codeErr(PROTOCOL)
+	ErrEmpty      = codeErr(EMPTY)
+	ErrSchema     = codeErr(SCHEMA)
+	ErrTooBig     = codeErr(TOOBIG)
+	ErrConstraint = codeErr(CONSTRAINT)
+	ErrMismatch   = codeErr(MISMATCH)
+	ErrMisuse     = codeErr(MISUSE)
+	ErrNoLFS      = codeErr(NOLFS)
+	ErrAuth       = codeErr(AUTH)
+	ErrFormat     = codeErr(FORMAT)
+	ErrRange      = codeErr(RANGE)
+	ErrNotADB     = codeErr(NOTADB)
+
+	ErrBusyRecovery         = codeErr(BUSY_RECOVERY)
+	ErrBusySnapshot         = codeErr(BUSY_SNAPSHOT)
+	ErrLockedSharedCache    = codeErr(LOCKED_SHAREDCACHE)
+	ErrReadOnlyRecovery     = codeErr(READONLY_RECOVERY)
+	ErrReadOnlyCantLock     = codeErr(READONLY_CANTLOCK)
+	ErrReadOnlyRollback     = codeErr(READONLY_ROLLBACK)
+	ErrReadOnlyDBMoved      = codeErr(READONLY_DBMOVED)
+	ErrAbortRollback        = codeErr(ABORT_ROLLBACK)
+	ErrCorruptVTab          = codeErr(CORRUPT_VTAB)
+	ErrConstraintCheck      = codeErr(CONSTRAINT_CHECK)
+	ErrConstraintCommitHook = codeErr(CONSTRAINT_COMMITHOOK)
+	ErrConstraintForeignKey = codeErr(CONSTRAINT_FOREIGNKEY)
+	ErrConstraintFunction   = codeErr(CONSTRAINT_FUNCTION)
+	ErrConstraintNotNull    = codeErr(CONSTRAINT_NOTNULL)
+	ErrConstraintPrimaryKey = codeErr(CONSTRAINT_PRIMARYKEY)
+	ErrConstraintTrigger    = codeErr(CONSTRAINT_TRIGGER)
+	ErrConstraintUnique     = codeErr(CONSTRAINT_UNIQUE)
+	ErrConstraintVTab       = codeErr(CONSTRAINT_VTAB)
+	ErrConstraintRowID      = codeErr(CONSTRAINT_ROWID)
+)
+
+// codeErr returns a result code value for errors.Is.
+func codeErr(rc int) *Error {
+	return &Error{rc: rc, msg: C.GoString(C.sqlite3_errstr(C.int(rc))), code: true}
+}
+
+// ErrNoCodec is returned by Conn.Key and Conn.Rekey if the package was built
+// without codec support (e.g. with the libsqlite3 tag).
+var ErrNoCodec = &Error{rc: ERROR, msg: "codec support is disabled"}
+
 // Complete returns true if sql appears to contain a complete statement that is
 // ready to be parsed. This does not validate the statement syntax.
 // [http://www.sqlite.org/c3ref/complete.html]
 func Complete(sql string) bool {
-	if initErr != nil {
+	if Initialize() != nil {
 		return false
 	}
 	sql += "\x00"
@@ -165,7 +319,7 @@ func Complete(sql string) bool {
 // SQLITE_ENABLE_MEMORY_MANAGEMENT option.
 // [http://www.sqlite.org/c3ref/release_memory.html]
 func ReleaseMemory(n int) int {
-	if initErr != nil {
+	if Initialize() != nil {
 		return 0
 	}
 	return int(C.sqlite3_release_memory(C.int(n)))
@@ -181,7 +335,7 @@ func ReleaseMemory(n int) int {
 // kept for backward compatibility when dynamic linking was supported in Go 1.0.
 // [http://www.sqlite.org/threadsafe.html]
 func SingleThread() bool {
-	return initErr == nil && C.sqlite3_threadsafe() == 0
+	return C.sqlite3_threadsafe() == 0
 }
 
 // SoftHeapLimit sets and/or queries the soft limit on the amount of heap memory
@@ -190,19 +344,36 @@ func SingleThread() bool {
 // negative values indicating an error.
 // [http://www.sqlite.org/c3ref/soft_heap_limit64.html]
 func SoftHeapLimit(n int64) int64 {
-	if initErr != nil {
+	if Initialize() != nil {
 		return -1
 	}
 	return int64(C.sqlite3_soft_heap_limit64(C.sqlite3_int64(n)))
 }
 
+// HardHeapLimit sets and/or queries the hard limit on the amount of heap memory
+// that may be allocated by SQLite. Allocations that would exceed the limit fail
+// with a NOMEM error. The soft limit is reduced to the hard limit if it is
+// higher. The argument and return value follow the same rules as
+// SoftHeapLimit. The hard limit requires SQLite 3.31.0 or later (libsqlite3
+// build tag). It is not supported by the bundled library, in which case an
+// error is returned and the limit is not set.
+// [http://www.sqlite.org/c3ref/hard_heap_limit64.html]
+func HardHeapLimit(n int64) (int64, error) {
+	if err := Initialize(); err != nil {
+		return -1, err
+	}
+	prev := int64(C.hard_heap_limit(C.sqlite3_int64(n)))
+	if prev < 0 {
+		return -1, pkgErr(ERROR, "hard heap limit requires SQLite 3.31.0 "+
+			"or later (running %s)", Version())
+	}
+	return prev, nil
+}
+
 // SourceId returns the check-in identifier of SQLite within its configuration
 // management system.
 // [http://www.sqlite.org/c3ref/c_source_id.html]
 func SourceId() string {
-	if initErr != nil {
-		return ""
-	}
 	return C.GoString(C.sqlite3_sourceid())
 }
 
@@ -211,8 +382,8 @@ func SourceId() string {
 // value is reset back down to the current value after retrieval.
 // [http://www.sqlite.org/c3ref/status.html]
 func Status(op int, reset bool) (cur, peak int, err error) {
-	if initErr != nil {
-		return 0, 0, initErr
+	if err := Initialize(); err != nil {
+		return 0, 0, err
 	}
 	var cCur, cPeak C.int
 	rc := C.sqlite3_status(C.int(op), &cCur, &cPeak, cBool(reset))
@@ -225,9 +396,6 @@ func Status(op int, reset bool) (cur, peak int, err error) {
 // Version returns the SQLite version as a string in the format "X.Y.Z[.N]".
 // [http://www.sqlite.org/c3ref/libversion.html]
 func Version() string {
-	if initErr != nil {
-		return ""
-	}
 	return goStr(C.sqlite3_libversion())
 }
 
@@ -235,46 +403,31 @@ func Version() string {
 // Y*1000 + Z, where X is the major version, Y is the minor version, and Z is
 // the release number.
 func VersionNum() int {
-	if initErr != nil {
-		return 0
-	}
 	return int(C.sqlite3_libversion_number())
 }
 
-// Print prints out all rows returned by a query. This function is intended as a
-// debugging aid and may be removed or altered in the future. Do not use it in
-// production applications.
+// Print prints out all rows returned by a query to os.Stdout in aligned
+// columns. It is a shortcut for calling Format on a zero Formatter, and should
+// be used only as a debugging aid.
 func Print(s *Stmt) error {
 	if s == nil || s.NumColumns() == 0 {
 		return nil
 	}
-	var err error
-	if !s.Busy() {
-		if err = s.Query(); err != nil {
-			return err
-		}
-	}
-	cols := s.Columns()
-	buf := bytes.NewBuffer(make([]byte, 0, len(cols)*10))
-	row := make(RowMap, len(cols))
+	return new(Formatter).Format(os.Stdout, s)
+}
 
-	buf.WriteByte('~')
-	for _, col := range cols {
-		fmt.Fprintf(buf, " %s ~", col)
-	}
-	fmt.Println(buf)
-	for ; err == nil; err = s.Next() {
-		if err = s.Scan(row); err != nil {
-			return err
-		}
-		buf.Reset()
-		buf.WriteByte('|')
-		for _, col := range cols {
-			fmt.Fprintf(buf, " %*v |", len(col), row[col])
-		}
-		fmt.Println(buf)
+// errCode returns the result code of err, which is ERROR for all errors that
+// did not originate in this package.
+func errCode(err error) int {
+	if e, ok := err.(*Error); ok {
+		return e.rc
 	}
-	return err
+	return ERROR
+}
+
+// quoteIdent returns s as a quoted SQL identifier.
+func quoteIdent(s string) string {
+	return `"` + strings.Replace(s, `"`, `""`, -1) + `"`
 }
 
 // raw casts s to a RawString.
@@ -373,3 +526,10 @@ func go_rollback_hook(c unsafe.Pointer) {
 func go_update_hook(c unsafe.Pointer, op C.int, db, tbl *C.char, row C.sqlite3_int64) {
 	(*Conn)(c).update(int(op), raw(goStr(db)), raw(goStr(tbl)), int64(row))
 }
+
+//export go_log
+func go_log(_ unsafe.Pointer, code C.int, msg *C.char) {
+	if f := loadLogger(); f != nil {
+		f(int(code), C.GoString(msg))
+	}
+}